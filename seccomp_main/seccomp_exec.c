@@ -1,9 +1,155 @@
+#define _GNU_SOURCE
 #include <seccomp.h>
 #include <errno.h>
+#include <fcntl.h>
+#include <dirent.h>
+#include <limits.h>
+#include <linux/landlock.h>
+#include <sched.h>
+#include <signal.h>
 #include <stdio.h>
-#include <unistd.h> 
+#include <stdlib.h>
+#include <string.h>
+#include <sys/mount.h>
+#include <sys/prctl.h>
+#include <sys/resource.h>
+#include <sys/stat.h>
+#include <sys/syscall.h>
+#include <sys/wait.h>
+#include <unistd.h>
 
-void install_seccomp_filter() {
+// limits the cpu time of the program to SPIELPLATZ_CPU_LIMIT seconds if it is set
+// the program gets SIGXCPU at the limit and SIGKILL one second later
+int set_cpu_limit() {
+    const char* limit = getenv("SPIELPLATZ_CPU_LIMIT");
+    if (limit == NULL) {
+        return 0;
+    }
+    rlim_t seconds = strtoul(limit, NULL, 10);
+    if (seconds == 0) {
+        return 0;
+    }
+    struct rlimit rl = { .rlim_cur = seconds, .rlim_max = seconds + 1 };
+    return setrlimit(RLIMIT_CPU, &rl);
+}
+
+// limits the size of every file the program writes to SPIELPLATZ_FILE_SIZE_LIMIT bytes if it is set
+int set_file_size_limit() {
+    const char* limit = getenv("SPIELPLATZ_FILE_SIZE_LIMIT");
+    if (limit == NULL) {
+        return 0;
+    }
+    rlim_t bytes = strtoull(limit, NULL, 10);
+    struct rlimit rl = { .rlim_cur = bytes, .rlim_max = bytes };
+    return setrlimit(RLIMIT_FSIZE, &rl);
+}
+
+// added in landlock ABI 3, missing in older headers
+#ifndef LANDLOCK_ACCESS_FS_TRUNCATE
+#define LANDLOCK_ACCESS_FS_TRUNCATE (1ULL << 14)
+#endif
+
+// how the program may access the files in its working directory
+enum file_access {
+    FILES_NONE,
+    FILES_READ,  // SPIELPLATZ_INPUT_DIR
+    FILES_WRITE, // SPIELPLATZ_OUTPUT_DIR
+};
+
+int allow_path(int ruleset, const char* path, __u64 access) {
+    struct landlock_path_beneath_attr attr = { .allowed_access = access };
+    attr.parent_fd = open(path, O_PATH | O_CLOEXEC);
+    if (attr.parent_fd < 0) {
+        return -1;
+    }
+    int err = syscall(__NR_landlock_add_rule, ruleset, LANDLOCK_RULE_PATH_BENEATH, &attr, 0);
+    close(attr.parent_fd);
+    return err;
+}
+
+// changes into dir and uses landlock so that the program can only access the files in it and execute itself
+// with FILES_WRITE it may also create, write and truncate files in dir, but not in subdirectories of it
+// linking or renaming files into other directories (ABI 2) and truncating (ABI 3) are never allowed otherwise
+// dir may be NULL, locale_dir (if not NULL) can be read as well so that the program can load its locale
+// fails if the kernel does not support landlock, the files are never accessible without it
+int restrict_files(const char* dir, const char* locale_dir, const char* exe, enum file_access access) {
+    int abi = syscall(__NR_landlock_create_ruleset, NULL, 0, LANDLOCK_CREATE_RULESET_VERSION);
+    if (abi < 0) {
+        return -1;
+    }
+    struct landlock_ruleset_attr attr = {
+        .handled_access_fs =
+            LANDLOCK_ACCESS_FS_EXECUTE |
+            LANDLOCK_ACCESS_FS_WRITE_FILE |
+            LANDLOCK_ACCESS_FS_READ_FILE |
+            LANDLOCK_ACCESS_FS_READ_DIR |
+            LANDLOCK_ACCESS_FS_REMOVE_DIR |
+            LANDLOCK_ACCESS_FS_REMOVE_FILE |
+            LANDLOCK_ACCESS_FS_MAKE_CHAR |
+            LANDLOCK_ACCESS_FS_MAKE_DIR |
+            LANDLOCK_ACCESS_FS_MAKE_REG |
+            LANDLOCK_ACCESS_FS_MAKE_SOCK |
+            LANDLOCK_ACCESS_FS_MAKE_FIFO |
+            LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+            LANDLOCK_ACCESS_FS_MAKE_SYM,
+    };
+    if (abi >= 2) {
+        attr.handled_access_fs |= LANDLOCK_ACCESS_FS_REFER;
+    }
+    if (abi >= 3) {
+        attr.handled_access_fs |= LANDLOCK_ACCESS_FS_TRUNCATE;
+    }
+    if (dir != NULL && chdir(dir) != 0) {
+        return -1;
+    }
+    int ruleset = syscall(__NR_landlock_create_ruleset, &attr, sizeof(attr), 0);
+    if (ruleset < 0) {
+        return -1;
+    }
+    __u64 dir_access = LANDLOCK_ACCESS_FS_READ_FILE | LANDLOCK_ACCESS_FS_READ_DIR;
+    if (access == FILES_WRITE) {
+        dir_access |= LANDLOCK_ACCESS_FS_WRITE_FILE | LANDLOCK_ACCESS_FS_MAKE_REG | (attr.handled_access_fs & LANDLOCK_ACCESS_FS_TRUNCATE);
+    }
+    int err = 0;
+    if (dir != NULL) {
+        err = allow_path(ruleset, dir, dir_access);
+    }
+    // a missing locale directory only means that the program uses the C locale
+    if (err == 0 && locale_dir != NULL) {
+        allow_path(ruleset, locale_dir, LANDLOCK_ACCESS_FS_READ_FILE | LANDLOCK_ACCESS_FS_READ_DIR);
+    }
+    if (err == 0) {
+        err = allow_path(ruleset, exe, LANDLOCK_ACCESS_FS_EXECUTE | LANDLOCK_ACCESS_FS_READ_FILE);
+    }
+    if (err == 0) {
+        err = prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0);
+    }
+    if (err == 0) {
+        err = syscall(__NR_landlock_restrict_self, ruleset, 0);
+    }
+    close(ruleset);
+    return err;
+}
+
+// landlock decides which files can be opened
+// without FILES_WRITE they may only be opened for reading
+void allow_files(scmp_filter_ctx ctx, enum file_access access) {
+    if (access == FILES_WRITE) {
+        seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(openat), 0);
+        seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(open), 0);
+    } else {
+        const int forbidden = O_ACCMODE | O_CREAT | O_TRUNC;
+        seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(openat), 1, SCMP_A2(SCMP_CMP_MASKED_EQ, forbidden, O_RDONLY));
+        seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(open), 1, SCMP_A1(SCMP_CMP_MASKED_EQ, forbidden, O_RDONLY));
+    }
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(close), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(lseek), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(fstat), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(newfstatat), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(statx), 0);
+}
+
+void install_seccomp_filter(enum file_access access) {
     // install seccomp filter that only allows some syscalls
     scmp_filter_ctx ctx;
     ctx = seccomp_init(SCMP_ACT_ERRNO(EPERM));
@@ -29,16 +175,261 @@ void install_seccomp_filter() {
     seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(getpid), 0);
     seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(gettid), 0);
     seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(mprotect), 0);
+    if (access != FILES_NONE) {
+        allow_files(ctx, access);
+    }
 
     seccomp_load(ctx);
     seccomp_release(ctx);
 }
 
+// writes the whole string s to the file path
+int write_file(const char* path, const char* s) {
+    int fd = open(path, O_WRONLY | O_CLOEXEC);
+    if (fd < 0) {
+        return -1;
+    }
+    ssize_t n = write(fd, s, strlen(s));
+    close(fd);
+    return n == (ssize_t)strlen(s) ? 0 : -1;
+}
+
+// copies the regular files in from_dir to to_dir, only writable ones if writable_only is set
+// files that already exist in to_dir and symlinks are skipped
+int copy_files(int from_dir, int to_dir, int writable_only) {
+    int fd = dup(from_dir);
+    if (fd < 0) {
+        return -1;
+    }
+    DIR* d = fdopendir(fd);
+    if (d == NULL) {
+        close(fd);
+        return -1;
+    }
+    rewinddir(d);
+    int err = 0;
+    struct dirent* entry;
+    while (err == 0 && (entry = readdir(d)) != NULL) {
+        int in = openat(from_dir, entry->d_name, O_RDONLY | O_NOFOLLOW | O_CLOEXEC);
+        if (in < 0) {
+            continue; // a directory or symlink
+        }
+        struct stat st;
+        if (fstat(in, &st) != 0 || !S_ISREG(st.st_mode) || (writable_only && (st.st_mode & S_IWUSR) == 0)) {
+            close(in);
+            continue;
+        }
+        int out = openat(to_dir, entry->d_name, O_WRONLY | O_CREAT | O_EXCL | O_NOFOLLOW | O_CLOEXEC, st.st_mode & 0777);
+        if (out < 0) {
+            close(in);
+            continue;
+        }
+        char buf[65536];
+        ssize_t n;
+        while ((n = read(in, buf, sizeof(buf))) > 0) {
+            if (write(out, buf, n) != n) {
+                err = -1;
+                break;
+            }
+        }
+        if (n < 0) {
+            err = -1;
+        }
+        close(in);
+        close(out);
+    }
+    closedir(d);
+    return err;
+}
+
+// bind mounts every file in dir read-only onto itself, so that the input files can not be written,
+// truncated, renamed or deleted, not even with the capabilities the program has in the user namespace
+int protect_files(const char* dir) {
+    DIR* d = opendir(dir);
+    if (d == NULL) {
+        return -1;
+    }
+    int err = 0;
+    char path[PATH_MAX];
+    struct dirent* entry;
+    while (err == 0 && (entry = readdir(d)) != NULL) {
+        if (entry->d_type != DT_REG) {
+            continue;
+        }
+        if (snprintf(path, sizeof(path), "%s/%s", dir, entry->d_name) >= (int)sizeof(path)) {
+            errno = ENAMETOOLONG;
+            err = -1;
+        } else if (mount(path, path, NULL, MS_BIND, NULL) != 0 ||
+                   mount(NULL, path, NULL, MS_REMOUNT | MS_BIND | MS_RDONLY | MS_NOSUID | MS_NODEV | MS_NOEXEC, NULL) != 0) {
+            err = -1;
+        }
+    }
+    closedir(d);
+    return err;
+}
+
+// mounts a tmpfs over dir in a new user and mount namespace, so that the files the program writes
+// can never exceed SPIELPLATZ_OUTPUT_MAX_BYTES (plus one page per file) and SPIELPLATZ_OUTPUT_MAX_FILES
+// the files already in dir (the input files) are copied into it and protected with protect_files
+// returns a file descriptor of the real dir, to copy the output files back after the program exited
+int mount_output_dir(const char* dir) {
+    const char* max_bytes = getenv("SPIELPLATZ_OUTPUT_MAX_BYTES");
+    const char* max_files = getenv("SPIELPLATZ_OUTPUT_MAX_FILES");
+    if (max_bytes == NULL || max_files == NULL) {
+        errno = EINVAL;
+        return -1;
+    }
+    unsigned long long files = strtoull(max_files, NULL, 10);
+    // tmpfs counts whole pages and the directory itself needs an inode
+    unsigned long long bytes = strtoull(max_bytes, NULL, 10) + files * sysconf(_SC_PAGESIZE);
+    char options[128];
+    snprintf(options, sizeof(options), "size=%llu,nr_inodes=%llu,mode=0700", bytes, files + 1);
+
+    int real_dir = open(dir, O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+    if (real_dir < 0) {
+        return -1;
+    }
+    // the ids are mapped to themselves, so that the copied files keep their owner
+    char uid_map[64], gid_map[64];
+    snprintf(uid_map, sizeof(uid_map), "%d %d 1", getuid(), getuid());
+    snprintf(gid_map, sizeof(gid_map), "%d %d 1", getgid(), getgid());
+    int tmp_dir = -1;
+    if (unshare(CLONE_NEWUSER | CLONE_NEWNS) != 0 ||
+        write_file("/proc/self/setgroups", "deny") != 0 ||
+        write_file("/proc/self/uid_map", uid_map) != 0 ||
+        write_file("/proc/self/gid_map", gid_map) != 0 ||
+        mount(NULL, "/", NULL, MS_REC | MS_PRIVATE, NULL) != 0 ||
+        mount("tmpfs", dir, "tmpfs", MS_NOSUID | MS_NODEV | MS_NOEXEC, options) != 0 ||
+        (tmp_dir = open(dir, O_RDONLY | O_DIRECTORY | O_CLOEXEC)) < 0 ||
+        copy_files(real_dir, tmp_dir, 0) != 0 ||
+        protect_files(dir) != 0) {
+        int err = errno;
+        if (tmp_dir >= 0) {
+            close(tmp_dir);
+        }
+        close(real_dir);
+        errno = err;
+        return -1;
+    }
+    close(tmp_dir);
+    return real_dir;
+}
+
+// runs the program in a child process and waits for it, so that the files can be copied out of the tmpfs afterwards
+// returns in the child, the parent exits like the program (with the same exit code or signal)
+void run_as_child(const char* dir, int real_dir) {
+    pid_t pid = fork();
+    if (pid < 0) {
+        perror("fork");
+        exit(1);
+    }
+    if (pid == 0) {
+        // the server kills seccomp_exec to stop the run
+        if (prctl(PR_SET_PDEATHSIG, SIGKILL) != 0 || getppid() == 1) {
+            _exit(1);
+        }
+        close(real_dir);
+        return;
+    }
+    // the server reads the graphics channel (fd 3 if it passed one) until the program exits
+    if (real_dir != 3) {
+        close(3);
+    }
+    int status;
+    while (waitpid(pid, &status, 0) < 0) {
+        if (errno != EINTR) {
+            perror("waitpid");
+            exit(1);
+        }
+    }
+    int tmp_dir = open(dir, O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+    if (tmp_dir < 0 || copy_files(tmp_dir, real_dir, 1) != 0) {
+        perror("Die geschriebenen Dateien konnten nicht kopiert werden");
+    }
+    if (WIFSIGNALED(status)) {
+        struct rlimit no_core = { 0, 0 };
+        setrlimit(RLIMIT_CORE, &no_core);
+        signal(WTERMSIG(status), SIG_DFL);
+        raise(WTERMSIG(status));
+        exit(128 + WTERMSIG(status));
+    }
+    exit(WEXITSTATUS(status));
+}
+
+// the server passes the environment of the program with this prefix
+#define PROGRAM_ENV_PREFIX "SPIELPLATZ_ENV_"
+
+// the environment of the program: the variables with PROGRAM_ENV_PREFIX (without it) and files_env
+// everything else in the environment of seccomp_exec is not passed on
+char** program_env(char* files_env) {
+    size_t n = 0;
+    while (environ[n] != NULL) {
+        n++;
+    }
+    char** envp = calloc(n + 2, sizeof(char*));
+    if (envp == NULL) {
+        return NULL;
+    }
+    const size_t prefix_len = strlen(PROGRAM_ENV_PREFIX);
+    size_t j = 0;
+    for (size_t i = 0; i < n; i++) {
+        if (strncmp(environ[i], PROGRAM_ENV_PREFIX, prefix_len) == 0) {
+            envp[j++] = environ[i] + prefix_len;
+        }
+    }
+    if (files_env != NULL) {
+        envp[j] = files_env;
+    }
+    return envp;
+}
+
 extern int ddp_ddpmain();
 
 int main(int argc, char* argv[]) {
-    install_seccomp_filter();
-    int err = execve(argv[1], argv + 1, NULL);
+    // must happen before the filter is installed, which forbids setrlimit
+    if (set_cpu_limit() != 0 || set_file_size_limit() != 0) {
+        perror("setrlimit");
+        return 1;
+    }
+    enum file_access access = FILES_NONE;
+    const char* dir = getenv("SPIELPLATZ_OUTPUT_DIR");
+    if (dir != NULL) {
+        access = FILES_WRITE;
+    } else if ((dir = getenv("SPIELPLATZ_INPUT_DIR")) != NULL) {
+        access = FILES_READ;
+    }
+    if (access == FILES_WRITE) {
+        int real_dir = mount_output_dir(dir);
+        if (real_dir < 0) {
+            perror("Dateien werden auf diesem Server nicht unterstützt");
+            return 1;
+        }
+        run_as_child(dir, real_dir);
+    }
+    const char* locale_dir = getenv("SPIELPLATZ_LOCALE_DIR");
+    if (access != FILES_NONE && restrict_files(dir, locale_dir, argv[1], access) != 0) {
+        perror("Dateien werden auf diesem Server nicht unterstützt");
+        return 1;
+    }
+    // without landlock the locale cannot be loaded and the program uses the C locale
+    if (access == FILES_NONE && locale_dir != NULL && restrict_files(NULL, locale_dir, argv[1], FILES_READ) == 0) {
+        access = FILES_READ;
+    }
+    // tells seccomp_main of the program which file access to allow
+    char* files_env = NULL;
+    if (access == FILES_WRITE) {
+        files_env = "SPIELPLATZ_FILES=write";
+    } else if (access == FILES_READ) {
+        files_env = "SPIELPLATZ_FILES=read";
+    }
+    char** envp = program_env(files_env);
+    if (envp == NULL) {
+        perror("calloc");
+        return 1;
+    }
+    install_seccomp_filter(access);
+    // the program inherits all open file descriptors, including the graphics channel (fd 3) if the server passed one
+    int err = execve(argv[1], argv + 1, envp);
     perror("execve");
     return 1;
 }
\ No newline at end of file
@@ -1,7 +1,10 @@
 #include "runtime/include/DDP/runtime.h"
 #include <errno.h>
+#include <fcntl.h>
 #include <seccomp.h>
 #include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
 
 void install_seccomp_filter() {
   // install seccomp filter that only allows some syscalls
@@ -19,6 +22,23 @@ void install_seccomp_filter() {
   seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(clock_gettime), 0);
   seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(clock_nanosleep), 0);
   seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(nanosleep), 0);
+  // set by seccomp_exec, which restricts the files with landlock
+  const char *files = getenv("SPIELPLATZ_FILES");
+  if (files != NULL && strcmp(files, "write") == 0) {
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(openat), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(open), 0);
+  } else if (files != NULL) {
+    const int forbidden = O_ACCMODE | O_CREAT | O_TRUNC;
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(openat), 1, SCMP_A2(SCMP_CMP_MASKED_EQ, forbidden, O_RDONLY));
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(open), 1, SCMP_A1(SCMP_CMP_MASKED_EQ, forbidden, O_RDONLY));
+  }
+  if (files != NULL) {
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(close), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(lseek), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(fstat), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(newfstatat), 0);
+    seccomp_rule_add(ctx, SCMP_ACT_ALLOW, SCMP_SYS(statx), 0);
+  }
 
   seccomp_load(ctx);
   seccomp_release(ctx);
@@ -26,9 +46,30 @@ void install_seccomp_filter() {
 
 extern int ddp_ddpmain();
 
+// a fixed seed for the random numbers of the runtime, checked by the server
+// covers everything that draws from the libc generators: rand and random (one state in glibc)
+// and the rand48 family, other sources are unavailable anyway (getrandom is not allowed
+// by the seccomp filter and /dev/urandom cannot be opened)
+void apply_seed() {
+  const char *seed = getenv("SPIELPLATZ_SEED");
+  if (seed == NULL || *seed == '\0') {
+    return;
+  }
+  char *end;
+  errno = 0;
+  unsigned long long value = strtoull(seed, &end, 10);
+  if (errno != 0 || *end != '\0') {
+    return;
+  }
+  srand((unsigned int)(value ^ (value >> 32)));
+  srand48((long)value);
+}
+
 int main(int argc, char *argv[]) {
   install_seccomp_filter();
   ddp_init_runtime(argc, argv);
+  // after ddp_init_runtime, which might seed rand itself
+  apply_seed();
   setvbuf(stdout, NULL, _IOLBF, 0);
   setvbuf(stderr, NULL, _IOLBF, 0);
   int ret = ddp_ddpmain();
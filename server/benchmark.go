@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/DDP-Projekt/Spielplatz/server/benchmark"
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+type BenchmarkRequest struct {
+	Src    string            `json:"src"`
+	Runs   int               `json:"runs"`
+	Inputs []benchmark.Input `json:"inputs"` // if empty the program is run once per repetition without input
+}
+
+type BenchmarkResponse struct {
+	Compilation kddp.ProgramResult[executables.TokenType] `json:"compilation"`
+	Results     []benchmark.Result                        `json:"results"`
+}
+
+// serves the /benchmark endpoint
+// compiles the program once and runs it repeatedly for every input
+func serve_benchmark(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req BenchmarkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("unmarshaling request", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Inputs) == 0 {
+		req.Inputs = []benchmark.Input{{}}
+	}
+	max_runs, max_total := viper.GetInt("max_benchmark_runs"), viper.GetInt("max_benchmark_total_runs")
+	if req.Runs < 1 || req.Runs > max_runs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Die Anzahl der Durchläufe muss zwischen 1 und %d liegen", max_runs)})
+		return
+	}
+	if req.Runs*len(req.Inputs) > max_total {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Es sind höchstens %d Durchläufe insgesamt erlaubt", max_total)})
+		return
+	}
+
+	logger.Info("compiling program for benchmark", "runs", req.Runs, "inputs", len(req.Inputs))
+	exe_path, compilation, cleanup, ok := compileOnce(c, logger, req.Src)
+	if !ok {
+		return
+	}
+	defer cleanup()
+	if compilation.Error != nil {
+		c.JSON(http.StatusOK, BenchmarkResponse{Compilation: compilation})
+		return
+	}
+
+	results := benchmark.Run(c.Request.Context(), exe_path, req.Inputs, req.Runs,
+		viper.GetDuration("benchmark_run_timeout"), processClient(c), logger,
+	)
+	logger.Info("benchmark finished")
+	c.JSON(http.StatusOK, BenchmarkResponse{
+		Compilation: compilation,
+		Results:     results,
+	})
+}
@@ -0,0 +1,103 @@
+/*
+package benchmark runs a compiled program repeatedly and collects timing statistics
+*/
+package benchmark
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	procqueue "github.com/DDP-Projekt/Spielplatz/server/proc_queue"
+)
+
+// an input the program is benchmarked with, e.g. a problem size
+type Input struct {
+	Label string   `json:"label"`
+	Args  []string `json:"args"`
+	Stdin string   `json:"stdin"`
+}
+
+// minimum, median and maximum in milliseconds
+type Stats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+type Result struct {
+	Label string `json:"label"`
+	// number of completed runs
+	Runs            int     `json:"runs"`
+	WallTime        Stats   `json:"wallTimeMs"`
+	CPUTime         Stats   `json:"cpuTimeMs"`
+	PeakMemoryBytes int64   `json:"peakMemoryBytes"`
+	ExitCode        int     `json:"exitCode"`
+	Error           *string `json:"error"` // set if a run failed, no further runs are made for this input
+}
+
+// runs the executable runs times for every input
+// every run waits in the process queue on its own and is limited to timeout
+func Run(ctx context.Context, exe_path string, inputs []Input, runs int, timeout time.Duration, client procqueue.Client, logger *slog.Logger) []Result {
+	results := make([]Result, 0, len(inputs))
+	for i, input := range inputs {
+		results = append(results, runInput(ctx, exe_path, input, runs, timeout, client, logger.With("input", i)))
+	}
+	return results
+}
+
+func runInput(ctx context.Context, exe_path string, input Input, runs int, timeout time.Duration, client procqueue.Client, logger *slog.Logger) Result {
+	result := Result{Label: input.Label}
+	wall_times := make([]time.Duration, 0, runs)
+	cpu_times := make([]time.Duration, 0, runs)
+
+	for range runs {
+		run, err := kddp.RunExecutable(ctx, exe_path, strings.NewReader(input.Stdin), io.Discard, io.Discard, kddp.RunOptions{
+			Args:    input.Args,
+			Client:  client,
+			Timeout: timeout,
+		}, logger)
+		err = kddp.IgnoreExitCode(run, err)
+		if err != nil {
+			logger.Info("benchmark run failed", "err", err)
+			err_string := err.Error()
+			result.Error = &err_string
+			break
+		}
+
+		result.Runs++
+		result.ExitCode = run.ExitCode
+		result.PeakMemoryBytes = max(result.PeakMemoryBytes, run.MaxRSS)
+		wall_times = append(wall_times, run.WallTime)
+		cpu_times = append(cpu_times, run.CPUTime)
+	}
+
+	result.WallTime = computeStats(wall_times)
+	result.CPUTime = computeStats(cpu_times)
+	return result
+}
+
+func computeStats(durations []time.Duration) Stats {
+	if len(durations) == 0 {
+		return Stats{}
+	}
+	slices.Sort(durations)
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	n := len(durations)
+	median := ms(durations[n/2])
+	if n%2 == 0 {
+		median = (ms(durations[n/2-1]) + ms(durations[n/2])) / 2
+	}
+	return Stats{
+		Min:    ms(durations[0]),
+		Median: median,
+		Max:    ms(durations[n-1]),
+	}
+}
@@ -0,0 +1,20 @@
+package benchmark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeStats(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(Stats{}, computeStats(nil))
+	assert.Equal(Stats{Min: 1, Median: 2, Max: 5}, computeStats([]time.Duration{
+		5 * time.Millisecond, time.Millisecond, 2 * time.Millisecond,
+	}))
+	assert.Equal(Stats{Min: 1, Median: 2.5, Max: 4}, computeStats([]time.Duration{
+		4 * time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond, time.Millisecond,
+	}))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/DDP-Projekt/Spielplatz/server/broadcast"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+var broadcastHub *broadcast.Hub
+
+func initBroadcasts() {
+	broadcastHub = broadcast.NewHub(broadcast.Config{
+		MaxBroadcasts:  viper.GetInt("broadcast_max_broadcasts"),
+		MaxViewers:     viper.GetInt("broadcast_max_viewers"),
+		MaxCodeLength:  viper.GetInt("broadcast_max_code_length"),
+		MaxOutputBytes: viper.GetInt("broadcast_max_output_bytes"),
+		IdleTimeout:    viper.GetDuration("broadcast_idle_timeout"),
+		SendBuffer:     viper.GetInt("broadcast_send_buffer"),
+	})
+}
+
+func broadcastErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, broadcast.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, broadcast.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, broadcast.ErrTooMany), errors.Is(err, broadcast.ErrTooManyViewers):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// serves the POST /broadcast endpoint
+func serve_create_broadcast(c *gin.Context) {
+	logger := getLogger(c)
+
+	id, token, err := broadcastHub.Create()
+	if err != nil {
+		logger.Warn("failed to create broadcast", "err", err)
+		c.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("created broadcast", "broadcast", id)
+	c.JSON(http.StatusOK, gin.H{
+		"broadcastId":    id,
+		"presenterToken": token,
+	})
+}
+
+// serves the /broadcast/:id/present websocket endpoint
+func serve_present_broadcast(c *gin.Context) {
+	logger := getLogger(c).With("broadcast", c.Param("id"))
+
+	bc, err := broadcastHub.Authorize(c.Param("id"), c.Query("token"))
+	if err != nil {
+		logger.Warn("rejected presenter", "err", err)
+		c.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Error("failed to initialize websocket connection", "err", err.Error())
+		return
+	}
+	defer ws.Close()
+
+	logger.Info("presenter connected")
+	if err := bc.Present(ws, logger); err != nil {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+	}
+	logger.Info("presenter disconnected")
+}
+
+// serves the /broadcast/:id/watch websocket endpoint
+func serve_watch_broadcast(c *gin.Context) {
+	logger := getLogger(c).With("broadcast", c.Param("id"))
+
+	bc, err := broadcastHub.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Error("failed to initialize websocket connection", "err", err.Error())
+		return
+	}
+	defer ws.Close()
+
+	if err := bc.Watch(ws, logger); err != nil {
+		logger.Warn("rejected viewer", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()))
+	}
+}
+
+// serves the /broadcast/:id/fork endpoint
+// returns the current code and output so a viewer can continue in their own editor
+func serve_fork_broadcast(c *gin.Context) {
+	state, err := broadcastHub.State(c.Param("id"))
+	if err != nil {
+		c.JSON(broadcastErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// returns the broadcast the run should be streamed to,
+// if the broadcast and broadcast_token query parameters are present and valid
+func runBroadcast(c *gin.Context, logger *slog.Logger) *broadcast.Broadcast {
+	id, ok := c.GetQuery("broadcast")
+	if !ok {
+		return nil
+	}
+	bc, err := broadcastHub.Authorize(id, c.Query("broadcast_token"))
+	if err != nil {
+		logger.Warn("not streaming run to broadcast", "err", err, "broadcast", id)
+		return nil
+	}
+	return bc
+}
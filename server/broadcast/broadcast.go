@@ -0,0 +1,279 @@
+/*
+package broadcast streams the editor content and run output of a presenter
+read-only to many viewers
+*/
+package broadcast
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	fanout "github.com/DDP-Projekt/Spielplatz/server/fan_out"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrNotFound       = errors.New("Unbekannte Übertragung")
+	ErrUnauthorized   = errors.New("Ungültiges Token")
+	ErrTooMany        = errors.New("Es gibt zu viele laufende Übertragungen")
+	ErrTooManyViewers = errors.New("Die Übertragung hat zu viele Zuschauer")
+	ErrCodeTooLong    = errors.New("Der Code ist zu lang")
+)
+
+type Config struct {
+	MaxBroadcasts int
+	MaxViewers    int
+	// in bytes
+	MaxCodeLength int
+	// how much of the run output is kept for viewers that join later
+	MaxOutputBytes int
+	// how long a broadcast without presenter is kept
+	IdleTimeout time.Duration
+	// number of messages buffered per viewer, slower viewers are disconnected
+	SendBuffer int
+}
+
+// manages all broadcasts
+type Hub struct {
+	mu         sync.Mutex
+	cfg        Config
+	broadcasts map[string]*Broadcast
+}
+
+func NewHub(cfg Config) *Hub {
+	return &Hub{
+		cfg:        cfg,
+		broadcasts: make(map[string]*Broadcast),
+	}
+}
+
+type OutputChunk struct {
+	Msg      string `json:"msg"`
+	IsStderr bool   `json:"isStderr"`
+}
+
+// the current state of a broadcast
+type State struct {
+	Code    string        `json:"code"`
+	Output  []OutputChunk `json:"output"`
+	Running bool          `json:"running"`
+}
+
+// a single broadcast session
+type Broadcast struct {
+	id          string
+	hub         *Hub
+	token_hash  []byte
+	mu          sync.Mutex
+	state       State
+	output_size int
+	presenting  bool
+	idle_since  time.Time
+	viewers     *fanout.Group[struct{}] // guarded by mu
+}
+
+// creates a new broadcast and returns its id and the presenter token
+func (h *Hub) Create() (string, string, error) {
+	token_bytes := make([]byte, 32)
+	if _, err := rand.Read(token_bytes); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(token_bytes)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.broadcasts) >= h.cfg.MaxBroadcasts {
+		return "", "", ErrTooMany
+	}
+	b := &Broadcast{
+		id:         uuid.NewString(),
+		hub:        h,
+		token_hash: hashToken(token),
+		idle_since: time.Now(),
+	}
+	b.viewers = fanout.New[struct{}](&b.mu)
+	h.broadcasts[b.id] = b
+	b.scheduleCleanup()
+	return b.id, token, nil
+}
+
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+func (h *Hub) Get(id string) (*Broadcast, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.broadcasts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+// returns the broadcast if token is its presenter token
+func (h *Hub) Authorize(id, token string) (*Broadcast, error) {
+	b, err := h.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hashToken(token), b.token_hash) != 1 {
+		return nil, ErrUnauthorized
+	}
+	return b, nil
+}
+
+// returns the current state of the broadcast, e.g. to fork it
+func (h *Hub) State(id string) (State, error) {
+	b, err := h.Get(id)
+	if err != nil {
+		return State{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	state.Output = append([]OutputChunk(nil), state.Output...)
+	return state, nil
+}
+
+func (b *Broadcast) scheduleCleanup() {
+	time.AfterFunc(b.hub.cfg.IdleTimeout, b.removeIfIdle)
+}
+
+func (b *Broadcast) removeIfIdle() {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.presenting || time.Since(b.idle_since) < b.hub.cfg.IdleTimeout {
+		return
+	}
+	delete(b.hub.broadcasts, b.id)
+	b.viewers.Broadcast(map[string]any{"type": "ended"}, nil)
+	b.viewers.Close(websocket.CloseNormalClosure, "")
+}
+
+type presenterMsg struct {
+	Type string `json:"type"` // "code"
+	Code string `json:"code"`
+}
+
+// serves the presenter connection until it is closed
+// only one presenter may be connected at a time
+func (b *Broadcast) Present(ws *websocket.Conn, logger *slog.Logger) error {
+	b.mu.Lock()
+	if b.presenting {
+		b.mu.Unlock()
+		return errors.New("Die Übertragung hat bereits einen Vortragenden")
+	}
+	b.presenting = true
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.presenting = false
+		b.idle_since = time.Now()
+		b.mu.Unlock()
+		b.scheduleCleanup()
+	}()
+
+	ws.SetReadLimit(int64(b.hub.cfg.MaxCodeLength) + 1024)
+	for {
+		var msg presenterMsg
+		if err := ws.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Info("presenter connection closed", "err", err)
+			}
+			return nil
+		}
+		switch msg.Type {
+		case "code":
+			if err := b.SetCode(msg.Code); err != nil {
+				ws.WriteJSON(map[string]any{"type": "error", "error": err.Error()})
+			}
+		default:
+			ws.WriteJSON(map[string]any{"type": "error", "error": "unknown message type"})
+		}
+	}
+}
+
+// updates the code shown to the viewers
+func (b *Broadcast) SetCode(code string) error {
+	if len(code) > b.hub.cfg.MaxCodeLength {
+		return ErrCodeTooLong
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Code = code
+	b.viewers.Broadcast(map[string]any{"type": "code", "code": code}, nil)
+	return nil
+}
+
+// clears the output and informs the viewers that a new run started
+func (b *Broadcast) StartRun() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Output = nil
+	b.output_size = 0
+	b.state.Running = true
+	b.viewers.Broadcast(map[string]any{"type": "runStarted"}, nil)
+}
+
+// informs the viewers that the run ended
+func (b *Broadcast) EndRun(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Running = false
+	b.viewers.Broadcast(map[string]any{"type": "runFinished", "reason": reason}, nil)
+}
+
+type outputWriter struct {
+	b         *Broadcast
+	is_stderr bool
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.b.addOutput(OutputChunk{Msg: string(p), IsStderr: w.is_stderr})
+	return len(p), nil
+}
+
+// a writer that forwards the written run output to the viewers
+// it never returns an error
+func (b *Broadcast) OutputWriter(is_stderr bool) io.Writer {
+	return outputWriter{b: b, is_stderr: is_stderr}
+}
+
+func (b *Broadcast) addOutput(chunk OutputChunk) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Output = append(b.state.Output, chunk)
+	b.output_size += len(chunk.Msg)
+	for b.output_size > b.hub.cfg.MaxOutputBytes && len(b.state.Output) > 1 {
+		b.output_size -= len(b.state.Output[0].Msg)
+		b.state.Output = b.state.Output[1:]
+	}
+	b.viewers.Broadcast(map[string]any{"type": "output", "msg": chunk.Msg, "isStderr": chunk.IsStderr}, nil)
+}
+
+// serves a viewer connection until it is closed
+func (b *Broadcast) Watch(ws *websocket.Conn, logger *slog.Logger) error {
+	err := b.viewers.Serve(ws, struct{}{}, b.hub.cfg.MaxViewers, b.hub.cfg.SendBuffer, func(v *fanout.Conn[struct{}]) {
+		b.viewers.Send(v, map[string]any{"type": "state", "state": b.state, "viewers": b.viewers.Len()})
+	}, logger)
+	switch {
+	case errors.Is(err, fanout.ErrFull):
+		return ErrTooManyViewers
+	case errors.Is(err, fanout.ErrClosed):
+		return ErrNotFound
+	}
+	return err
+}
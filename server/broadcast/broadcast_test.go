@@ -0,0 +1,166 @@
+package broadcast
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHub() *Hub {
+	return NewHub(Config{
+		MaxBroadcasts:  2,
+		MaxViewers:     2,
+		MaxCodeLength:  16,
+		MaxOutputBytes: 8,
+		IdleTimeout:    time.Minute,
+		SendBuffer:     16,
+	})
+}
+
+// a server that serves every websocket connection with serve
+func wsServer(t *testing.T, serve func(ws *websocket.Conn)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		serve(ws)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	con, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { con.Close() })
+	return con
+}
+
+func readMsg(t *testing.T, con *websocket.Conn) map[string]any {
+	var msg map[string]any
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, con.ReadJSON(&msg))
+	return msg
+}
+
+func TestHub(t *testing.T) {
+	assert := assert.New(t)
+	h := testHub()
+
+	id, token, err := h.Create()
+	assert.NoError(err)
+	_, _, err = h.Create()
+	assert.NoError(err)
+	_, _, err = h.Create()
+	assert.ErrorIs(err, ErrTooMany)
+
+	_, err = h.Get("unbekannt")
+	assert.ErrorIs(err, ErrNotFound)
+	_, err = h.Authorize(id, "falsch")
+	assert.ErrorIs(err, ErrUnauthorized)
+	b, err := h.Authorize(id, token)
+	assert.NoError(err)
+
+	assert.ErrorIs(b.SetCode(strings.Repeat("x", 17)), ErrCodeTooLong)
+	assert.NoError(b.SetCode("Schreibe 1."))
+	b.StartRun()
+	b.OutputWriter(false).Write([]byte("12345"))
+	b.OutputWriter(true).Write([]byte("678"))
+	b.OutputWriter(false).Write([]byte("9"))
+
+	// only the newest MaxOutputBytes of output are kept
+	state, err := h.State(id)
+	assert.NoError(err)
+	assert.Equal(State{
+		Code:    "Schreibe 1.",
+		Output:  []OutputChunk{{Msg: "678", IsStderr: true}, {Msg: "9"}},
+		Running: true,
+	}, state)
+	state.Output[0].Msg = "geändert"
+	state, _ = h.State(id)
+	assert.Equal("678", state.Output[0].Msg)
+
+	b.EndRun("exited")
+	state, _ = h.State(id)
+	assert.False(state.Running)
+}
+
+func TestIdleBroadcastIsRemoved(t *testing.T) {
+	assert := assert.New(t)
+	h := testHub()
+	h.cfg.IdleTimeout = 10 * time.Millisecond
+
+	id, _, err := h.Create()
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		_, err := h.Get(id)
+		return err != nil
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestPresenterAndViewers(t *testing.T) {
+	assert := assert.New(t)
+	h := testHub()
+	id, _, err := h.Create()
+	require.NoError(t, err)
+	b, _ := h.Get(id)
+
+	present_err := make(chan error, 2)
+	presenter_url := wsServer(t, func(ws *websocket.Conn) { present_err <- b.Present(ws, slog.Default()) })
+	watch_err := make(chan error, 3)
+	viewer_url := wsServer(t, func(ws *websocket.Conn) { watch_err <- b.Watch(ws, slog.Default()) })
+
+	viewer := dial(t, viewer_url)
+	msg := readMsg(t, viewer)
+	assert.Equal("state", msg["type"])
+	assert.Equal(float64(1), msg["viewers"])
+	dial(t, viewer_url)
+	dial(t, viewer_url)
+	assert.ErrorIs(<-watch_err, ErrTooManyViewers)
+
+	presenter := dial(t, presenter_url)
+	assert.NoError(presenter.WriteJSON(presenterMsg{Type: "code", Code: "Schreibe 1."}))
+	msg = readMsg(t, viewer)
+	assert.Equal("code", msg["type"])
+	assert.Equal("Schreibe 1.", msg["code"])
+
+	// there is only one presenter at a time
+	dial(t, presenter_url)
+	assert.Error(<-present_err)
+
+	// errors go back to the presenter only
+	assert.NoError(presenter.WriteJSON(presenterMsg{Type: "code", Code: strings.Repeat("x", 17)}))
+	msg = readMsg(t, presenter)
+	assert.Equal(ErrCodeTooLong.Error(), msg["error"])
+
+	b.StartRun()
+	b.OutputWriter(true).Write([]byte("Fehler"))
+	b.EndRun("exited")
+	assert.Equal("runStarted", readMsg(t, viewer)["type"])
+	msg = readMsg(t, viewer)
+	assert.Equal(map[string]any{"type": "output", "msg": "Fehler", "isStderr": true}, msg)
+	assert.Equal("runFinished", readMsg(t, viewer)["type"])
+
+	// without presenter the broadcast ends after the idle timeout
+	presenter.Close()
+	assert.NoError(<-present_err)
+	b.mu.Lock()
+	b.idle_since = time.Now().Add(-time.Hour)
+	b.mu.Unlock()
+	b.removeIfIdle()
+	assert.Equal("ended", readMsg(t, viewer)["type"])
+	_, _, err = viewer.ReadMessage()
+	assert.True(websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	assert.NoError(<-watch_err)
+	_, err = h.Get(id)
+	assert.ErrorIs(err, ErrNotFound)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/DDP-Projekt/Spielplatz/server/collab"
+	ratelimit "github.com/DDP-Projekt/Spielplatz/server/rate_limit"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+var collabManager *collab.Manager
+
+// limits how many sessions a single client creates, so that one client can not use up collab_max_sessions
+var collabCreateLimiter *ratelimit.Limiter
+
+func initCollab() {
+	collabManager = collab.NewManager(collab.Config{
+		MaxSessions:       viper.GetInt("collab_max_sessions"),
+		MaxParticipants:   viper.GetInt("collab_max_participants"),
+		MaxDocumentLength: viper.GetInt("collab_max_document_length"),
+		MaxHistory:        viper.GetInt("collab_max_history"),
+		IdleTimeout:       viper.GetDuration("collab_idle_timeout"),
+		SendBuffer:        viper.GetInt("collab_send_buffer"),
+	}, func(code string) (string, error) {
+		return createShareLink(code, SharedRun{})
+	})
+	collabCreateLimiter = ratelimit.New(viper.GetInt("collab_sessions_per_client"), viper.GetDuration("collab_sessions_window"))
+}
+
+type CreateCollabSessionRequest struct {
+	Code string `json:"code"`
+}
+
+// serves the POST /collab endpoint
+func serve_create_collab_session(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req CreateCollabSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("failed to bind json", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if !collabCreateLimiter.Allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Zu viele Sitzungen erstellt, bitte später erneut versuchen"})
+		return
+	}
+
+	id, err := collabManager.Create(req.Code)
+	if errors.Is(err, collab.ErrDocumentTooLong) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, collab.ErrTooManySessions) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("failed to create collaborative session", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	logger.Info("created collaborative session", "session", id)
+	c.JSON(http.StatusOK, gin.H{"sessionId": id})
+}
+
+// serves the /collab/:id websocket endpoint
+func serve_collab(c *gin.Context) {
+	logger := getLogger(c)
+
+	name := c.Query("name")
+	if name == "" {
+		name = "Anonym"
+	}
+	if utf8.RuneCountInString(name) > 50 {
+		name = string([]rune(name)[:50])
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Error("failed to initialize websocket connection", "err", err.Error())
+		return
+	}
+	defer ws.Close()
+
+	if err := collabManager.Serve(ws, c.Param("id"), name, logger); err != nil {
+		logger.Warn("could not join collaborative session", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+	}
+}
@@ -0,0 +1,417 @@
+/*
+package collab implements collaborative editing sessions
+
+clients edit a shared document by sending operations (see Operation)
+against the last revision they know, the server transforms them
+against all concurrent operations and broadcasts the result
+*/
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrSessionNotFound = errors.New("Unbekannte Sitzung")
+	ErrTooManySessions = errors.New("Es gibt zu viele offene Sitzungen")
+	ErrSessionFull     = errors.New("Die Sitzung ist voll")
+	ErrDocumentTooLong = errors.New("Das Dokument ist zu lang")
+)
+
+type Config struct {
+	MaxSessions     int
+	MaxParticipants int
+	// in UTF-16 code units
+	MaxDocumentLength int
+	// number of operations kept to transform operations of lagging clients
+	MaxHistory int
+	// how long a session without participants is kept
+	IdleTimeout time.Duration
+	// size of the per participant send buffer, slower participants are disconnected
+	SendBuffer int
+}
+
+// creates a share link for the given code and returns its id
+type SnapshotFunc func(code string) (string, error)
+
+// manages all collaborative editing sessions
+type Manager struct {
+	mu       sync.Mutex
+	cfg      Config
+	sessions map[string]*Session
+	snapshot SnapshotFunc
+}
+
+func NewManager(cfg Config, snapshot SnapshotFunc) *Manager {
+	return &Manager{
+		cfg:      cfg,
+		sessions: make(map[string]*Session),
+		snapshot: snapshot,
+	}
+}
+
+// creates a new session with the given initial document and returns its id
+func (m *Manager) Create(doc string) (string, error) {
+	content := utf16.Encode([]rune(doc))
+	if len(content) > m.cfg.MaxDocumentLength {
+		return "", ErrDocumentTooLong
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sessions) >= m.cfg.MaxSessions {
+		return "", ErrTooManySessions
+	}
+
+	s := &Session{
+		id:           uuid.NewString(),
+		manager:      m,
+		doc:          content,
+		participants: make(map[string]*participant),
+		empty_since:  time.Now(),
+	}
+	m.sessions[s.id] = s
+	s.scheduleCleanup()
+	return s.id, nil
+}
+
+func (m *Manager) get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+// removes the session if it still has no participants
+func (m *Manager) removeIfIdle(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.participants) == 0 && time.Since(s.empty_since) >= m.cfg.IdleTimeout {
+		delete(m.sessions, s.id)
+	}
+}
+
+// a cursor or selection in UTF-16 code units
+type Cursor struct {
+	Position     int `json:"position"`
+	SelectionEnd int `json:"selectionEnd"`
+}
+
+func (c *Cursor) transform(op Operation) {
+	c.Position = op.TransformIndex(c.Position)
+	c.SelectionEnd = op.TransformIndex(c.SelectionEnd)
+}
+
+func (c *Cursor) clamp(doc_len int) *Cursor {
+	return &Cursor{
+		Position:     min(max(c.Position, 0), doc_len),
+		SelectionEnd: min(max(c.SelectionEnd, 0), doc_len),
+	}
+}
+
+type Presence struct {
+	ClientID string  `json:"clientId"`
+	Name     string  `json:"name"`
+	Cursor   *Cursor `json:"cursor"`
+}
+
+func (p Presence) clone() Presence {
+	if p.Cursor != nil {
+		cursor := *p.Cursor
+		p.Cursor = &cursor
+	}
+	return p
+}
+
+type participant struct {
+	Presence
+	send chan serverMsg
+	conn *websocket.Conn
+}
+
+// a single collaborative editing session
+type Session struct {
+	id           string
+	manager      *Manager
+	mu           sync.Mutex
+	doc          []uint16
+	revision     int
+	history      []Operation // the operations that lead to the last len(history) revisions
+	participants map[string]*participant
+	empty_since  time.Time
+}
+
+type serverMsg struct {
+	Type         string     `json:"type"`
+	ClientID     string     `json:"clientId,omitempty"`
+	Revision     *int       `json:"revision,omitempty"`
+	Doc          *string    `json:"doc,omitempty"`
+	Op           Operation  `json:"op,omitempty"`
+	Participants []Presence `json:"participants,omitempty"`
+	Presence     *Presence  `json:"presence,omitempty"`
+	ShareCode    string     `json:"shareCode,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+type clientMsg struct {
+	Type     string          `json:"type"` // "op", "cursor" or "snapshot"
+	Revision int             `json:"revision"`
+	Op       json.RawMessage `json:"op"` // parsed with the limits of the session in ParseOperation
+	Cursor   *Cursor         `json:"cursor"`
+}
+
+// serves a participant on ws until the connection is closed
+func (m *Manager) Serve(ws *websocket.Conn, session_id, name string, logger *slog.Logger) error {
+	s, ok := m.get(session_id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	// join queues the init message while the session is locked, so it has to fit into the buffer
+	p := &participant{
+		Presence: Presence{ClientID: uuid.NewString(), Name: name},
+		send:     make(chan serverMsg, max(m.cfg.SendBuffer, 1)),
+		conn:     ws,
+	}
+	logger = logger.With("session", session_id, "client", p.ClientID)
+	if err := s.join(p); err != nil {
+		return err
+	}
+	logger.Info("joined collaborative session")
+
+	writer_done := make(chan struct{})
+	go func() {
+		p.writeLoop(logger)
+		close(writer_done)
+	}()
+	defer func() {
+		s.leave(p)
+		<-writer_done
+	}()
+
+	// an operation can insert the whole document, every code unit takes up to 6 bytes in json (\uXXXX)
+	ws.SetReadLimit(int64(m.cfg.MaxDocumentLength)*6 + 1024)
+	for {
+		var msg clientMsg
+		if err := ws.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Info("collaborative session connection closed", "err", err)
+			}
+			return nil
+		}
+
+		switch msg.Type {
+		case "op":
+			op, err := ParseOperation(msg.Op, m.cfg.MaxDocumentLength)
+			if errors.Is(err, errOperationTooLong) {
+				err = ErrDocumentTooLong
+			}
+			if err == nil {
+				err = s.applyOperation(p, msg.Revision, op, msg.Cursor)
+			}
+			if err != nil {
+				logger.Warn("rejected operation", "err", err)
+				s.sendTo(p, serverMsg{Type: "error", Error: err.Error()})
+			}
+		case "cursor":
+			s.updateCursor(p, msg.Cursor)
+		case "snapshot":
+			code, err := m.takeSnapshot(s)
+			if err != nil {
+				logger.Error("failed to snapshot session", "err", err)
+				s.sendTo(p, serverMsg{Type: "error", Error: "Der Zustand konnte nicht geteilt werden"})
+				continue
+			}
+			logger.Info("created snapshot of session", "share_code", code)
+			s.broadcast(serverMsg{Type: "snapshot", ClientID: p.ClientID, ShareCode: code})
+		default:
+			s.sendTo(p, serverMsg{Type: "error", Error: "unknown message type"})
+		}
+	}
+}
+
+func (m *Manager) takeSnapshot(s *Session) (string, error) {
+	s.mu.Lock()
+	doc := string(utf16.Decode(s.doc))
+	s.mu.Unlock()
+	return m.snapshot(doc)
+}
+
+func (p *participant) writeLoop(logger *slog.Logger) {
+	for msg := range p.send {
+		p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := p.conn.WriteJSON(msg); err != nil {
+			logger.Info("failed to write to participant", "err", err)
+			p.conn.Close()
+			// drain the channel until the session removes the participant
+			for range p.send {
+			}
+			return
+		}
+	}
+}
+
+func (s *Session) join(p *participant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.participants) >= s.manager.cfg.MaxParticipants {
+		return ErrSessionFull
+	}
+
+	participants := make([]Presence, 0, len(s.participants))
+	for _, other := range s.participants {
+		participants = append(participants, other.Presence.clone())
+	}
+	s.participants[p.ClientID] = p
+
+	doc := string(utf16.Decode(s.doc))
+	revision := s.revision
+	p.send <- serverMsg{
+		Type:         "init",
+		ClientID:     p.ClientID,
+		Revision:     &revision,
+		Doc:          &doc,
+		Participants: participants,
+	}
+	s.broadcastLocked(serverMsg{Type: "join", Presence: &p.Presence}, p)
+	return nil
+}
+
+func (s *Session) leave(p *participant) {
+	s.mu.Lock()
+	s.removeLocked(p)
+	empty := len(s.participants) == 0
+	if empty {
+		s.empty_since = time.Now()
+	}
+	s.mu.Unlock()
+	if empty {
+		s.scheduleCleanup()
+	}
+}
+
+// s.mu must be held
+func (s *Session) removeLocked(p *participant) {
+	if _, ok := s.participants[p.ClientID]; !ok {
+		return
+	}
+	delete(s.participants, p.ClientID)
+	close(p.send)
+	s.broadcastLocked(serverMsg{Type: "leave", ClientID: p.ClientID}, nil)
+}
+
+func (s *Session) scheduleCleanup() {
+	time.AfterFunc(s.manager.cfg.IdleTimeout, func() {
+		s.manager.removeIfIdle(s)
+	})
+}
+
+func (s *Session) applyOperation(p *participant, revision int, op Operation, cursor *Cursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest := s.revision - len(s.history)
+	if revision < oldest || revision > s.revision {
+		return errors.New("invalid revision, please resync")
+	}
+	for _, concurrent := range s.history[revision-oldest:] {
+		op_prime, concurrent_prime, err := Transform(op, concurrent)
+		if err != nil {
+			return err
+		}
+		op = op_prime
+		// the cursor is relative to the document of the client
+		if cursor != nil {
+			cursor.transform(concurrent_prime)
+		}
+	}
+	if l := op.TargetLen(); l < 0 || l > s.manager.cfg.MaxDocumentLength {
+		return ErrDocumentTooLong
+	}
+	doc, err := op.Apply(s.doc)
+	if err != nil {
+		return err
+	}
+
+	s.doc = doc
+	s.revision++
+	s.history = append(s.history, op)
+	if len(s.history) > s.manager.cfg.MaxHistory {
+		s.history = s.history[len(s.history)-s.manager.cfg.MaxHistory:]
+	}
+
+	for _, other := range s.participants {
+		if other.Cursor != nil {
+			other.Cursor.transform(op)
+		}
+	}
+	if cursor != nil {
+		p.Cursor = cursor.clamp(len(s.doc))
+	}
+
+	rev := s.revision
+	s.sendLocked(p, serverMsg{Type: "ack", Revision: &rev})
+	s.broadcastLocked(serverMsg{Type: "op", ClientID: p.ClientID, Revision: &rev, Op: op, Presence: &p.Presence}, p)
+	return nil
+}
+
+func (s *Session) updateCursor(p *participant, cursor *Cursor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cursor != nil {
+		cursor = cursor.clamp(len(s.doc))
+	}
+	p.Cursor = cursor
+	s.broadcastLocked(serverMsg{Type: "presence", Presence: &p.Presence}, p)
+}
+
+func (s *Session) sendTo(p *participant, msg serverMsg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(p, msg)
+}
+
+func (s *Session) broadcast(msg serverMsg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broadcastLocked(msg, nil)
+}
+
+// sends msg to every participant except skip
+// s.mu must be held
+func (s *Session) broadcastLocked(msg serverMsg, skip *participant) {
+	for _, p := range s.participants {
+		if p != skip {
+			s.sendLocked(p, msg)
+		}
+	}
+}
+
+// queues msg for p and disconnects p if it can't keep up
+// s.mu must be held
+func (s *Session) sendLocked(p *participant, msg serverMsg) {
+	if _, ok := s.participants[p.ClientID]; !ok {
+		return
+	}
+	// presence is copied, because cursors change after the message is queued
+	if msg.Presence != nil {
+		presence := msg.Presence.clone()
+		msg.Presence = &presence
+	}
+	select {
+	case p.send <- msg:
+	default:
+		p.conn.Close()
+		s.removeLocked(p)
+	}
+}
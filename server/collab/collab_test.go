@@ -0,0 +1,51 @@
+package collab
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a server that lets every websocket connection take part in session_id
+func collabServer(t *testing.T, m *Manager, session_id string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		m.Serve(ws, session_id, "anna", slog.Default())
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestServe(t *testing.T) {
+	assert := assert.New(t)
+	// without a send buffer the init message must not block the session
+	m := NewManager(Config{MaxSessions: 1, MaxParticipants: 2, MaxDocumentLength: 10, MaxHistory: 10, IdleTimeout: time.Minute}, nil)
+	id, err := m.Create("hallo")
+	require.NoError(t, err)
+
+	con, _, err := websocket.DefaultDialer.Dial(collabServer(t, m, id), nil)
+	require.NoError(t, err)
+	defer con.Close()
+
+	var msg serverMsg
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, con.ReadJSON(&msg))
+	assert.Equal("init", msg.Type)
+	assert.Equal("hallo", *msg.Doc)
+
+	// frames larger than any valid operation close the connection
+	assert.NoError(con.WriteMessage(websocket.TextMessage, []byte(`{"type": "op", "op": ["`+strings.Repeat("x", 2000)+`"]}`)))
+	_, _, err = con.ReadMessage()
+	assert.True(websocket.IsCloseError(err, websocket.CloseMessageTooBig), err)
+}
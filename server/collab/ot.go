@@ -0,0 +1,313 @@
+package collab
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// a single component of an Operation, exactly one of the fields is set
+type component struct {
+	retain int
+	insert []uint16
+	delete int
+}
+
+// an operational transformation in the format used by ot.js:
+// a list of retains (positive numbers), inserts (strings) and deletes (negative numbers)
+// all lengths are counted in UTF-16 code units, like JavaScript strings
+type Operation []component
+
+// the longest operation UnmarshalJSON accepts, ParseOperation takes a smaller limit
+const maxOperationLength = math.MaxInt32
+
+var (
+	errIncompatibleOperations = errors.New("operations are not compatible")
+	errOperationTooLong       = errors.New("operation is too long")
+)
+
+func (op *Operation) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	if l := len(*op); l > 0 && (*op)[l-1].retain > 0 {
+		(*op)[l-1].retain += n
+		return
+	}
+	*op = append(*op, component{retain: n})
+}
+
+func (op *Operation) insert(s []uint16) {
+	if len(s) == 0 {
+		return
+	}
+	ops := *op
+	l := len(ops)
+	switch {
+	case l > 0 && ops[l-1].insert != nil:
+		ops[l-1].insert = append(ops[l-1].insert, s...)
+	case l > 0 && ops[l-1].delete > 0:
+		// inserts always come before deletes at the same position
+		if l > 1 && ops[l-2].insert != nil {
+			ops[l-2].insert = append(ops[l-2].insert, s...)
+		} else {
+			ops = append(ops, ops[l-1])
+			ops[l-1] = component{insert: append([]uint16(nil), s...)}
+		}
+	default:
+		ops = append(ops, component{insert: append([]uint16(nil), s...)})
+	}
+	*op = ops
+}
+
+func (op *Operation) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	if l := len(*op); l > 0 && (*op)[l-1].delete > 0 {
+		(*op)[l-1].delete += n
+		return
+	}
+	*op = append(*op, component{delete: n})
+}
+
+// adds n to the length sum, reports false if the result would be negative or exceed max
+func addLength(sum, n, max int) (int, bool) {
+	if n < 0 || n > max-sum {
+		return 0, false
+	}
+	return sum + n, true
+}
+
+// length of the document the operation can be applied to, -1 if it overflows
+func (op Operation) BaseLen() int {
+	n, ok := 0, true
+	for _, c := range op {
+		if n, ok = addLength(n, c.retain, math.MaxInt); !ok {
+			return -1
+		}
+		if n, ok = addLength(n, c.delete, math.MaxInt); !ok {
+			return -1
+		}
+	}
+	return n
+}
+
+// length of the document after applying the operation, -1 if it overflows
+func (op Operation) TargetLen() int {
+	n, ok := 0, true
+	for _, c := range op {
+		if n, ok = addLength(n, c.retain, math.MaxInt); !ok {
+			return -1
+		}
+		if n, ok = addLength(n, len(c.insert), math.MaxInt); !ok {
+			return -1
+		}
+	}
+	return n
+}
+
+// applies the operation to doc and returns the new document
+func (op Operation) Apply(doc []uint16) ([]uint16, error) {
+	base, target := op.BaseLen(), op.TargetLen()
+	if base < 0 || target < 0 {
+		return nil, errOperationTooLong
+	}
+	if base != len(doc) {
+		return nil, fmt.Errorf("operation has base length %d but the document has length %d", base, len(doc))
+	}
+	result := make([]uint16, 0, target)
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			result = append(result, doc[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.insert != nil:
+			result = append(result, c.insert...)
+		default:
+			pos += c.delete
+		}
+	}
+	return result, nil
+}
+
+// transforms two concurrent operations a and b that apply to the same document
+// into a' and b', such that apply(apply(doc, a), b') == apply(apply(doc, b), a')
+// if both insert at the same position, the insert of a comes first
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if a.BaseLen() < 0 || a.TargetLen() < 0 || b.TargetLen() < 0 {
+		return nil, nil, errOperationTooLong
+	}
+	if a.BaseLen() != b.BaseLen() {
+		return nil, nil, errIncompatibleOperations
+	}
+
+	var a_prime, b_prime Operation
+	i, j := 0, 0
+	// the remaining part of the current components
+	var ca, cb *component
+	next := func(op Operation, idx *int) *component {
+		if *idx >= len(op) {
+			return nil
+		}
+		c := op[*idx]
+		*idx++
+		return &c
+	}
+	ca, cb = next(a, &i), next(b, &j)
+
+	for ca != nil || cb != nil {
+		if ca != nil && ca.insert != nil {
+			a_prime.insert(ca.insert)
+			b_prime.retain(len(ca.insert))
+			ca = next(a, &i)
+			continue
+		}
+		if cb != nil && cb.insert != nil {
+			a_prime.retain(len(cb.insert))
+			b_prime.insert(cb.insert)
+			cb = next(b, &j)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, errIncompatibleOperations
+		}
+
+		len_a, len_b := ca.retain+ca.delete, cb.retain+cb.delete
+		n := min(len_a, len_b)
+		switch {
+		case ca.retain > 0 && cb.retain > 0:
+			a_prime.retain(n)
+			b_prime.retain(n)
+		case ca.delete > 0 && cb.retain > 0:
+			a_prime.delete(n)
+		case ca.retain > 0 && cb.delete > 0:
+			b_prime.delete(n)
+		}
+		// when both delete the same range nothing has to be done
+
+		if len_a == n {
+			ca = next(a, &i)
+		} else {
+			shorten(ca, n)
+		}
+		if len_b == n {
+			cb = next(b, &j)
+		} else {
+			shorten(cb, n)
+		}
+	}
+	return a_prime, b_prime, nil
+}
+
+func shorten(c *component, n int) {
+	if c.retain > 0 {
+		c.retain -= n
+	} else {
+		c.delete -= n
+	}
+}
+
+// maps a position in the document before op to the position after op
+func (op Operation) TransformIndex(index int) int {
+	new_index := index
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			index -= c.retain
+		case c.insert != nil:
+			new_index += len(c.insert)
+		default:
+			new_index -= min(index, c.delete)
+			index -= c.delete
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return new_index
+}
+
+func (op Operation) MarshalJSON() ([]byte, error) {
+	parts := make([]any, 0, len(op))
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			parts = append(parts, c.retain)
+		case c.insert != nil:
+			parts = append(parts, string(utf16.Decode(c.insert)))
+		default:
+			parts = append(parts, -c.delete)
+		}
+	}
+	return json.Marshal(parts)
+}
+
+func (op *Operation) UnmarshalJSON(data []byte) error {
+	result, err := ParseOperation(data, maxOperationLength)
+	if err != nil {
+		return err
+	}
+	*op = result
+	return nil
+}
+
+// parses an operation from json
+// operations with a base or target length above max_length are rejected
+func ParseOperation(data []byte, max_length int) (Operation, error) {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return nil, err
+	}
+
+	var result Operation
+	base, target, ok := 0, 0, true
+	for _, part := range parts {
+		if bytes.HasPrefix(bytes.TrimSpace(part), []byte(`"`)) {
+			var s string
+			if err := json.Unmarshal(part, &s); err != nil {
+				return nil, err
+			}
+			if s == "" {
+				return nil, errors.New("empty insert in operation")
+			}
+			insert := utf16.Encode([]rune(s))
+			if target, ok = addLength(target, len(insert), max_length); !ok {
+				return nil, errOperationTooLong
+			}
+			result.insert(insert)
+			continue
+		}
+
+		var n int
+		if err := json.Unmarshal(part, &n); err != nil {
+			return nil, fmt.Errorf("invalid operation component: %w", err)
+		}
+		switch {
+		case n > 0:
+			if base, ok = addLength(base, n, max_length); !ok {
+				return nil, errOperationTooLong
+			}
+			if target, ok = addLength(target, n, max_length); !ok {
+				return nil, errOperationTooLong
+			}
+			result.retain(n)
+		case n < 0:
+			// -n overflows for math.MinInt
+			if n < -max_length {
+				return nil, errOperationTooLong
+			}
+			if base, ok = addLength(base, -n, max_length); !ok {
+				return nil, errOperationTooLong
+			}
+			result.delete(-n)
+		default:
+			return nil, errors.New("zero length component in operation")
+		}
+	}
+	return result, nil
+}
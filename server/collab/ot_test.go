@@ -0,0 +1,92 @@
+package collab
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseOp(t *testing.T, s string) Operation {
+	var op Operation
+	assert.NoError(t, json.Unmarshal([]byte(s), &op))
+	return op
+}
+
+func apply(t *testing.T, doc string, op Operation) string {
+	result, err := op.Apply(utf16.Encode([]rune(doc)))
+	assert.NoError(t, err)
+	return string(utf16.Decode(result))
+}
+
+func TestOperationJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	op := parseOp(t, `[2, "ä😀", -1, 1]`)
+	assert.Equal(4, op.BaseLen())
+	assert.Equal(6, op.TargetLen()) // the emoji has two UTF-16 code units
+
+	data, err := json.Marshal(op)
+	assert.NoError(err)
+	assert.JSONEq(`[2, "ä😀", -1, 1]`, string(data))
+
+	assert.Error(json.Unmarshal([]byte(`[0]`), &op))
+	assert.Error(json.Unmarshal([]byte(`[""]`), &op))
+}
+
+func TestTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := "Schreibe 1."
+	cases := [][2]string{
+		{`[9, "x", 2]`, `[9, "y", 2]`},
+		{`[9, -1, 1]`, `[9, -1, 1]`},
+		{`[-9, 2]`, `[8, "!", 3]`},
+		{`["Binde ", 11]`, `[9, -2, "42."]`},
+		{`[3, -5, "abc", 3]`, `[1, -9, 1]`},
+	}
+	for _, c := range cases {
+		a, b := parseOp(t, c[0]), parseOp(t, c[1])
+		a_prime, b_prime, err := Transform(a, b)
+		assert.NoError(err)
+		assert.Equal(apply(t, apply(t, doc, a), b_prime), apply(t, apply(t, doc, b), a_prime), c)
+	}
+
+	_, _, err := Transform(parseOp(t, `[1]`), parseOp(t, `[2]`))
+	assert.Error(err)
+}
+
+func TestTransformIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	op := parseOp(t, `[2, "abc", -2, 3]`)
+	assert.Equal(1, op.TransformIndex(1))
+	assert.Equal(5, op.TransformIndex(2))
+	assert.Equal(5, op.TransformIndex(3))
+	assert.Equal(6, op.TransformIndex(5))
+}
+
+func TestOperationLength(t *testing.T) {
+	assert := assert.New(t)
+
+	var op Operation
+	assert.ErrorIs(json.Unmarshal([]byte(`[9223372036854775807, -9223372036854775807, 3]`), &op), errOperationTooLong)
+	assert.ErrorIs(json.Unmarshal([]byte(`[-9223372036854775808]`), &op), errOperationTooLong)
+
+	_, err := ParseOperation([]byte(`[2, "abc"]`), 5)
+	assert.NoError(err)
+	_, err = ParseOperation([]byte(`[2, "abcd"]`), 5)
+	assert.ErrorIs(err, errOperationTooLong)
+	_, err = ParseOperation([]byte(`[3, -3]`), 5)
+	assert.ErrorIs(err, errOperationTooLong)
+
+	// operations built without ParseOperation are checked as well
+	huge := Operation{{retain: math.MaxInt}, {delete: math.MaxInt}, {retain: 3}}
+	assert.Equal(-1, huge.BaseLen())
+	_, err = huge.Apply([]uint16{'a'})
+	assert.ErrorIs(err, errOperationTooLong)
+	_, _, err = Transform(huge, huge)
+	assert.ErrorIs(err, errOperationTooLong)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollabSessionsPerClient(t *testing.T) {
+	assert := assert.New(t)
+	setConfig(t, "collab_max_sessions", 10)
+	setConfig(t, "collab_max_document_length", 1024)
+	setConfig(t, "collab_sessions_per_client", 2)
+	setConfig(t, "collab_sessions_window", time.Minute)
+	old_manager, old_limiter := collabManager, collabCreateLimiter
+	t.Cleanup(func() { collabManager, collabCreateLimiter = old_manager, old_limiter })
+	initCollab()
+
+	r := testRouter()
+	r.POST("/collab", serve_create_collab_session)
+	assert.Equal(http.StatusOK, request(r, http.MethodPost, "/collab", gin.H{"code": ""}, nil).Code)
+	assert.Equal(http.StatusOK, request(r, http.MethodPost, "/collab", gin.H{"code": ""}, nil).Code)
+	assert.Equal(http.StatusTooManyRequests, request(r, http.MethodPost, "/collab", gin.H{"code": ""}, nil).Code)
+}
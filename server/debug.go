@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/DDP-Projekt/Spielplatz/server/debugger"
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+// the name of the DDP source file as shown to debug clients
+const debugSourceName = "Spielplatz.ddp"
+
+func removeDebugSource(exe_path string, logger *slog.Logger) {
+	if err := os.Remove(kddp.DebugSourcePath(exe_path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("failed to delete debug source file", "err", err)
+	}
+}
+
+// serves the /debug endpoint
+// the program of the token (compiled with "debug": true) is run under gdb
+// and every websocket message is one message of the Debug Adapter Protocol
+func serve_debug(c *gin.Context) {
+	logger := getLogger(c)
+	logger.Info("new debug request")
+	if kddp.QueueFull() {
+		logger.Warn("process queue is full, rejecting debug request")
+		serveBusy(c, kddp.ErrServerBusy)
+		return
+	}
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Error("failed to initialize websocket connection", "err", err.Error())
+		return
+	}
+	defer ws.Close()
+
+	ti, err := strconv.ParseInt(c.Query("token"), 10, 64)
+	if err != nil {
+		logger.Warn("invalid token")
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, "invalid token"))
+		return
+	}
+	token := executables.TokenType(ti)
+	// a debug session counts as run of the token
+	exe_path, release, ok := acquireExecutable(ws, nil, token, logger)
+	if !ok {
+		return
+	}
+	defer release()
+	logger = logger.With("token", token, "exe_path", exe_path)
+
+	src_path, err := filepath.Abs(kddp.DebugSourcePath(exe_path))
+	if err == nil {
+		_, err = os.Stat(src_path)
+	}
+	if err != nil {
+		logger.Warn("executable was not compiled for debugging", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Das Programm wurde nicht für den Debugger kompiliert"))
+		return
+	}
+	abs_exe_path, err := filepath.Abs(exe_path)
+	if err != nil {
+		logger.Error("failed to get absolute path to executable", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error"))
+		return
+	}
+
+	args, _ := c.GetQueryArray("args")
+	// the same environment and input files as with /run
+	env_vars, _ := c.GetQueryArray("env")
+	env, err := runEnv(env_vars)
+	if err != nil {
+		logger.Warn("invalid environment", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, err.Error()))
+		return
+	}
+	input_dir := inputFiles(exe_path)
+	cwd := ""
+	if input_dir != "" {
+		if cwd, err = filepath.Abs(input_dir); err != nil {
+			logger.Error("failed to get absolute path to input files", "err", err)
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error"))
+			return
+		}
+	}
+	to_gdb_r, to_gdb_w := io.Pipe()
+	from_gdb_r, from_gdb_w := io.Pipe()
+	gdb_done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := kddp.RunDebugger(ctx, exe_path, to_gdb_r, from_gdb_w, kddp.RunOptions{
+			Client:   processClient(c),
+			InputDir: input_dir,
+			Env:      env,
+		}, logger)
+		gdb_done <- err
+		// lets the bridge notice that gdb exited
+		from_gdb_w.Close()
+		to_gdb_r.Close()
+	}()
+
+	logger.Info("starting debug session", "args", args, "env", env)
+	bridge_err := debugger.Bridge(ws, to_gdb_w, from_gdb_r, debugger.Config{
+		Program:          abs_exe_path,
+		Args:             args,
+		Cwd:              cwd,
+		SourcePath:       src_path,
+		ClientSourceName: debugSourceName,
+		MaxMessageSize:   viper.GetInt("debug_max_message_bytes"),
+	}, logger)
+	if bridge_err != nil {
+		logger.Warn("debug session ended with error", "err", bridge_err)
+	}
+
+	select {
+	case err = <-gdb_done:
+	default:
+		// the client left, gdb (or the wait for a process slot) is stopped
+		cancel()
+		<-gdb_done
+		err = nil
+	}
+	switch {
+	case isBusyErr(err):
+		logger.Warn("no process slot for debug session", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, busyMessage(err)))
+	case err != nil:
+		logger.Warn("debugger exited with error", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+	default:
+		logger.Info("debug session ended")
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Die Debug-Sitzung wurde beendet"))
+	}
+}
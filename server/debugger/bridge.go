@@ -0,0 +1,302 @@
+package debugger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type Config struct {
+	// the debugged executable and its arguments, clients cannot choose them
+	Program string
+	Args    []string
+	// working directory of the program (e.g. with the input files), the directory of Program if empty
+	Cwd string
+	// path of the DDP source file on the server and the name shown to clients instead
+	SourcePath       string
+	ClientSourceName string
+	MaxMessageSize   int
+}
+
+// requests that clients may send, everything else is rejected
+// e.g. attach or source could be used to access the server through gdb
+var allowedCommands = map[string]bool{
+	"initialize":              true,
+	"launch":                  true,
+	"configurationDone":       true,
+	"disconnect":              true,
+	"terminate":               true,
+	"setBreakpoints":          true,
+	"setFunctionBreakpoints":  true,
+	"setExceptionBreakpoints": true,
+	"threads":                 true,
+	"stackTrace":              true,
+	"scopes":                  true,
+	"variables":               true,
+	"evaluate":                true,
+	"continue":                true,
+	"next":                    true,
+	"stepIn":                  true,
+	"stepOut":                 true,
+	"pause":                   true,
+}
+
+type bridge struct {
+	cfg        Config
+	ws         *websocket.Conn
+	writeMutex sync.Mutex
+	gdb_in     io.WriteCloser
+	gdb_out    *frameReader
+	logger     *slog.Logger
+}
+
+// forwards DAP messages between ws and gdb until one side closed the connection
+// requests are checked and rewritten so that clients only see and debug cfg.Program
+func Bridge(ws *websocket.Conn, gdb_in io.WriteCloser, gdb_out io.Reader, cfg Config, logger *slog.Logger) error {
+	b := &bridge{
+		cfg:     cfg,
+		ws:      ws,
+		gdb_in:  gdb_in,
+		gdb_out: newFrameReader(gdb_out, cfg.MaxMessageSize),
+		logger:  logger,
+	}
+	ws.SetReadLimit(int64(cfg.MaxMessageSize))
+
+	errs := make(chan error, 2)
+	go func() { errs <- b.clientToGdb() }()
+	go func() { errs <- b.gdbToClient() }()
+	err := <-errs
+	// gdb exits when its input is closed
+	gdb_in.Close()
+	return err
+}
+
+func (b *bridge) clientToGdb() error {
+	for {
+		msg_type, data, err := b.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		if msg_type != websocket.TextMessage {
+			continue
+		}
+
+		msg, err := decode(data)
+		if err != nil {
+			return fmt.Errorf("invalid DAP message: %w", err)
+		}
+		if msg["type"] != "request" {
+			continue
+		}
+		if reason := b.checkRequest(msg); reason != "" {
+			b.logger.Warn("rejected DAP request", "command", msg["command"], "reason", reason)
+			if err := b.writeClient(errorResponse(msg, reason)); err != nil {
+				return err
+			}
+			continue
+		}
+		b.rewriteRequest(msg)
+
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := writeFrame(b.gdb_in, body); err != nil {
+			return fmt.Errorf("failed to write to gdb: %w", err)
+		}
+	}
+}
+
+func (b *bridge) gdbToClient() error {
+	for {
+		body, err := b.gdb_out.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read from gdb: %w", err)
+		}
+		msg, err := decode(body)
+		if err != nil {
+			return fmt.Errorf("gdb sent an invalid DAP message: %w", err)
+		}
+		if err := b.writeClient(b.rewriteFromGdb(msg)); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *bridge) writeClient(msg any) error {
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+	b.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return b.ws.WriteJSON(msg)
+}
+
+// returns why the request is not allowed or "" if it is
+// expressions are evaluated inside of the program, so they must not call functions or assign anything
+func (b *bridge) checkRequest(msg map[string]any) string {
+	command, _ := msg["command"].(string)
+	if !allowedCommands[command] {
+		return "Diese Anfrage wird nicht unterstützt"
+	}
+	args, _ := msg["arguments"].(map[string]any)
+	unsafe := "Dieser Ausdruck kann nicht ausgewertet werden"
+	switch command {
+	case "evaluate":
+		context, _ := args["context"].(string)
+		expression, _ := args["expression"].(string)
+		// the repl context runs gdb commands
+		if context == "repl" || !safeExpression(expression) {
+			return unsafe
+		}
+	case "setBreakpoints", "setFunctionBreakpoints":
+		breakpoints, _ := args["breakpoints"].([]any)
+		for _, bp := range breakpoints {
+			bp, _ := bp.(map[string]any)
+			if !safeBreakpoint(bp) {
+				return unsafe
+			}
+			if name, ok := bp["name"].(string); command == "setFunctionBreakpoints" && (!ok || !safeFunctionName(name)) {
+				return "Ungültiger Funktionsname"
+			}
+		}
+	case "setExceptionBreakpoints":
+		options, _ := args["filterOptions"].([]any)
+		for _, option := range options {
+			option, _ := option.(map[string]any)
+			if condition, ok := option["condition"].(string); ok && !safeExpression(condition) {
+				return unsafe
+			}
+		}
+	}
+	return ""
+}
+
+// reports whether the condition, hit condition and log message of a breakpoint are safe
+func safeBreakpoint(bp map[string]any) bool {
+	for _, key := range []string{"condition", "hitCondition"} {
+		if expr, ok := bp[key]; ok {
+			if expr, ok := expr.(string); !ok || !safeExpression(expr) {
+				return false
+			}
+		}
+	}
+	if msg, ok := bp["logMessage"]; ok {
+		if msg, ok := msg.(string); !ok || !safeLogMessage(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bridge) cwd() string {
+	if b.cfg.Cwd != "" {
+		return b.cfg.Cwd
+	}
+	return filepath.Dir(b.cfg.Program)
+}
+
+// replaces the launch arguments and maps client source names to server paths
+func (b *bridge) rewriteRequest(msg map[string]any) {
+	args, _ := msg["arguments"].(map[string]any)
+	if msg["command"] == "launch" {
+		launch := map[string]any{
+			"program": b.cfg.Program,
+			"args":    b.cfg.Args,
+			"cwd":     b.cwd(),
+		}
+		if stop, ok := args["stopAtBeginningOfMainSubprogram"].(bool); ok {
+			launch["stopAtBeginningOfMainSubprogram"] = stop
+		}
+		msg["arguments"] = launch
+		return
+	}
+	if args == nil {
+		return
+	}
+	mapStrings(args, func(s string) string {
+		if s == b.cfg.ClientSourceName {
+			return b.cfg.SourcePath
+		}
+		return s
+	})
+}
+
+// hides server paths from the client and de-emphasizes frames outside of the DDP program
+func (b *bridge) rewriteFromGdb(msg map[string]any) any {
+	if msg["type"] == "response" && msg["command"] == "stackTrace" {
+		body, _ := msg["body"].(map[string]any)
+		frames, _ := body["stackFrames"].([]any)
+		for _, frame := range frames {
+			frame, ok := frame.(map[string]any)
+			if !ok {
+				continue
+			}
+			source, _ := frame["source"].(map[string]any)
+			if path, _ := source["path"].(string); path != b.cfg.SourcePath {
+				frame["presentationHint"] = "subtle"
+			}
+		}
+	}
+	replacer := strings.NewReplacer(
+		b.cfg.SourcePath, b.cfg.ClientSourceName,
+		b.cfg.Program, filepath.Base(b.cfg.Program),
+	)
+	return mapStrings(msg, replacer.Replace)
+}
+
+func errorResponse(request map[string]any, message string) map[string]any {
+	return map[string]any{
+		"seq":         0,
+		"type":        "response",
+		"request_seq": request["seq"],
+		"success":     false,
+		"command":     request["command"],
+		"message":     message,
+	}
+}
+
+func decode(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var msg map[string]any
+	if err := dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("expected a json object")
+	}
+	return msg, nil
+}
+
+// applies f to all strings in a decoded json value
+func mapStrings(v any, f func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		return f(v)
+	case map[string]any:
+		for key, value := range v {
+			v[key] = mapStrings(value, f)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = mapStrings(value, f)
+		}
+		return v
+	default:
+		return v
+	}
+}
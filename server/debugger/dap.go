@@ -0,0 +1,73 @@
+/*
+package debugger bridges the Debug Adapter Protocol (DAP) between a websocket and gdb
+
+every websocket text message contains exactly one DAP message,
+towards gdb the messages are framed with Content-Length headers
+as described in https://microsoft.github.io/debug-adapter-protocol/overview#base-protocol
+*/
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrMessageTooLarge = errors.New("DAP message too large")
+
+// reads Content-Length framed messages
+type frameReader struct {
+	r        *bufio.Reader
+	max_size int
+}
+
+func newFrameReader(r io.Reader, max_size int) *frameReader {
+	return &frameReader{r: bufio.NewReader(r), max_size: max_size}
+}
+
+// returns the body of the next message
+func (fr *frameReader) next() ([]byte, error) {
+	length := -1
+	for {
+		line, err := fr.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid DAP header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	if length > fr.max_size {
+		return nil, ErrMessageTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(fr.r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// writes body as a Content-Length framed message
+func writeFrame(w io.Writer, body []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,117 @@
+package debugger
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrames(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	assert.NoError(writeFrame(&buf, []byte(`{"seq":1}`)))
+	assert.NoError(writeFrame(&buf, []byte(`{"seq":2}`)))
+
+	fr := newFrameReader(&buf, 100)
+	body, err := fr.next()
+	assert.NoError(err)
+	assert.Equal(`{"seq":1}`, string(body))
+	body, err = fr.next()
+	assert.NoError(err)
+	assert.Equal(`{"seq":2}`, string(body))
+
+	fr = newFrameReader(bytes.NewBufferString("Content-Length: 10\r\n\r\n0123456789"), 5)
+	_, err = fr.next()
+	assert.ErrorIs(err, ErrMessageTooLarge)
+}
+
+func TestCheckRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &bridge{}
+	request := func(data string) map[string]any {
+		msg, err := decode([]byte(data))
+		assert.NoError(err)
+		return msg
+	}
+	assert.Empty(b.checkRequest(request(`{"command":"next","arguments":{"threadId":1}}`)))
+	assert.Empty(b.checkRequest(request(`{"command":"evaluate","arguments":{"expression":"x + 1","context":"hover"}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"attach","arguments":{"pid":1}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"evaluate","arguments":{"expression":"shell ls","context":"repl"}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"evaluate","arguments":{"expression":"$_shell(\"ls\")","context":"watch"}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"evaluate","arguments":{"expression":"system(\"ls\")","context":"hover"}}`)))
+
+	assert.Empty(b.checkRequest(request(`{"command":"setBreakpoints","arguments":{"breakpoints":[{"line":3,"condition":"i > 2","logMessage":"i ist {i}"}]}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"setBreakpoints","arguments":{"breakpoints":[{"line":3,"condition":"system(0)"}]}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"setBreakpoints","arguments":{"breakpoints":[{"line":3,"hitCondition":"i = 2"}]}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"setBreakpoints","arguments":{"breakpoints":[{"line":3,"logMessage":"{abort()}"}]}}`)))
+	assert.Empty(b.checkRequest(request(`{"command":"setFunctionBreakpoints","arguments":{"breakpoints":[{"name":"ddp_ddpmain"}]}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"setFunctionBreakpoints","arguments":{"breakpoints":[{"name":"*abort()"}]}}`)))
+	assert.NotEmpty(b.checkRequest(request(`{"command":"setExceptionBreakpoints","arguments":{"filterOptions":[{"filterId":"x","condition":"x++"}]}}`)))
+}
+
+func TestSafeExpression(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, expr := range []string{
+		"x", "x + 1", "zahlen[i-1]", "p->wert", "(int)x", "ä == 2 && !b", "-x", "a::b", "x >= 0x1f", "größe % 2",
+		"*p", "&x", "(char*)p", "a.b.c", "a[1][j]", "1.5 * (x - 2)", "x > 0 ? x : -x",
+	} {
+		assert.True(safeExpression(expr), expr)
+	}
+	for _, expr := range []string{
+		"system(0)", "f (x)", "(f)(x)", "a[0](1)", "x = 1", "x += 1", "x++", "--x", "x <<= 1",
+		`"abc"`, "'a'", "$pc", "{int}0", "x@2", "`ls`", "sizeof(x)",
+		"sizeof x", "x, f()", "(int)(f)(x)", "a b", "x +", "()", "1u", "1.2.3", "a.", "a[1", "x ? 1", "a.b(1)", "a->f (1)",
+	} {
+		assert.False(safeExpression(expr), expr)
+	}
+
+	assert.True(safeLogMessage("i ist {i}, j ist {j + 1}"))
+	assert.False(safeLogMessage("{i"))
+	assert.False(safeLogMessage("{i = 0}"))
+	assert.False(safeFunctionName("*0x1234"))
+	assert.False(safeFunctionName("a b"))
+	assert.True(safeFunctionName("Klasse::f"))
+}
+
+func TestRewrite(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &bridge{cfg: Config{
+		Program:          "/tmp/exes/Spielplatz_1",
+		Args:             []string{"a"},
+		SourcePath:       "/tmp/exes/Spielplatz_1.ddp",
+		ClientSourceName: "Spielplatz.ddp",
+	}}
+
+	launch, _ := decode([]byte(`{"command":"launch","arguments":{"program":"/bin/sh","stopAtBeginningOfMainSubprogram":true}}`))
+	b.rewriteRequest(launch)
+	assert.Equal(map[string]any{
+		"program":                         "/tmp/exes/Spielplatz_1",
+		"args":                            []string{"a"},
+		"cwd":                             "/tmp/exes",
+		"stopAtBeginningOfMainSubprogram": true,
+	}, launch["arguments"])
+
+	breakpoints, _ := decode([]byte(`{"command":"setBreakpoints","arguments":{"source":{"path":"Spielplatz.ddp"}}}`))
+	b.rewriteRequest(breakpoints)
+	assert.Equal("/tmp/exes/Spielplatz_1.ddp", breakpoints["arguments"].(map[string]any)["source"].(map[string]any)["path"])
+
+	trace, _ := decode([]byte(`{"type":"response","command":"stackTrace","body":{"stackFrames":[
+		{"name":"main","source":{"path":"/tmp/exes/Spielplatz_1.ddp"}},
+		{"name":"ddp_init_runtime","source":{"path":"/DDP/lib/runtime.c"}}
+	]}}`))
+	frames := b.rewriteFromGdb(trace).(map[string]any)["body"].(map[string]any)["stackFrames"].([]any)
+	assert.Equal("Spielplatz.ddp", frames[0].(map[string]any)["source"].(map[string]any)["path"])
+	assert.Nil(frames[0].(map[string]any)["presentationHint"])
+	assert.Equal("subtle", frames[1].(map[string]any)["presentationHint"])
+
+	// the program starts in the directory of its input files
+	b.cfg.Cwd = "/tmp/exes/Spielplatz_1.files"
+	launch, _ = decode([]byte(`{"command":"launch","arguments":{"cwd":"/"}}`))
+	b.rewriteRequest(launch)
+	assert.Equal("/tmp/exes/Spielplatz_1.files", launch["arguments"].(map[string]any)["cwd"])
+}
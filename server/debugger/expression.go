@@ -0,0 +1,248 @@
+package debugger
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// the operators an expression may contain, longer ones first
+// assignments (=, +=, ++, ...) are missing on purpose, so are @, {}, $ and string literals
+// (gdb allocates string literals in the memory of the program by calling malloc)
+var allowedOperators = []string{
+	"->", "==", "!=", "<=", ">=", "&&", "||", "::", "<<", ">>",
+	".", "[", "]", "(", ")", "+", "-", "*", "/", "%", "<", ">", "!", "&", "|", "^", "~", "?", ":",
+}
+
+var (
+	binaryOperators = []string{"*", "/", "%", "+", "-", "<<", ">>", "<", "<=", ">", ">=", "==", "!=", "&", "^", "|", "&&", "||"}
+	unaryOperators  = []string{"-", "+", "!", "~", "*", "&"}
+	numberLiteral   = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|[0-9]+(\.[0-9]+)?)$`)
+)
+
+// reports whether gdb can evaluate expr without side effects in the program
+// expr has to match this grammar, everything else (calls, assignments, ...) is rejected:
+//
+//	expr    = binary [ "?" expr ":" expr ]
+//	binary  = unary { binop unary }
+//	unary   = { unop | "(" type ")" } postfix
+//	postfix = primary { "." name | "->" name | "[" expr "]" }
+//	primary = name { "::" name } | number | "(" expr ")"
+//	type    = name { "::" name } { "*" }
+func safeExpression(expr string) bool {
+	// x++ or x-- would be read as two operators
+	if strings.Contains(expr, "++") || strings.Contains(expr, "--") {
+		return false
+	}
+	tokens, ok := tokenize(expr)
+	if !ok {
+		return false
+	}
+	p := &exprParser{tokens: tokens}
+	return p.expr() && p.pos == len(p.tokens)
+}
+
+// splits expr into names, numbers and allowed operators
+func tokenize(expr string) ([]string, bool) {
+	isNameRune := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	var tokens []string
+	for rest := expr; rest != ""; {
+		r := []rune(rest)[0]
+		switch {
+		case unicode.IsSpace(r):
+			rest = rest[len(string(r)):]
+			continue
+		case isNameRune(r):
+			end := strings.IndexFunc(rest, func(r rune) bool { return !isNameRune(r) && r != '.' })
+			if end < 0 {
+				end = len(rest)
+			}
+			token := rest[:end]
+			// the dot only belongs to numbers (1.5), otherwise it is the member operator
+			if !unicode.IsDigit(r) {
+				token, _, _ = strings.Cut(token, ".")
+			} else if !numberLiteral.MatchString(token) {
+				return nil, false
+			}
+			tokens = append(tokens, token)
+			rest = rest[len(token):]
+			continue
+		}
+
+		op := ""
+		for _, allowed := range allowedOperators {
+			if strings.HasPrefix(rest, allowed) {
+				op = allowed
+				break
+			}
+		}
+		if op == "" {
+			return nil, false
+		}
+		tokens = append(tokens, op)
+		rest = rest[len(op):]
+	}
+	return tokens, true
+}
+
+// checks the tokens of an expression against the grammar of safeExpression
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek(offset int) string {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return ""
+}
+
+// consumes the next token if it is one of tokens
+func (p *exprParser) accept(tokens ...string) bool {
+	if slices.Contains(tokens, p.peek(0)) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func isName(token string) bool {
+	r := []rune(token)
+	return len(r) > 0 && (r[0] == '_' || unicode.IsLetter(r[0]))
+}
+
+func (p *exprParser) name() bool {
+	if !isName(p.peek(0)) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// name { "::" name }
+func (p *exprParser) qualifiedName() bool {
+	if !p.name() {
+		return false
+	}
+	for p.accept("::") {
+		if !p.name() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *exprParser) expr() bool {
+	if !p.binary() {
+		return false
+	}
+	if p.accept("?") {
+		return p.expr() && p.accept(":") && p.expr()
+	}
+	return true
+}
+
+func (p *exprParser) binary() bool {
+	if !p.unary() {
+		return false
+	}
+	for p.accept(binaryOperators...) {
+		if !p.unary() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *exprParser) unary() bool {
+	for {
+		if p.accept(unaryOperators...) || p.cast() {
+			continue
+		}
+		return p.postfix()
+	}
+}
+
+// consumes "(" type ")" if it is followed by a name or number
+// "(f)(x)" is not a cast but a call, so no parenthesis may follow
+func (p *exprParser) cast() bool {
+	start := p.pos
+	if p.accept("(") && p.qualifiedName() {
+		for p.accept("*") {
+		}
+		if p.accept(")") {
+			if next := p.peek(0); isName(next) || numberLiteral.MatchString(next) {
+				return true
+			}
+		}
+	}
+	p.pos = start
+	return false
+}
+
+func (p *exprParser) postfix() bool {
+	if !p.primary() {
+		return false
+	}
+	for {
+		switch {
+		case p.accept(".", "->"):
+			if !p.name() {
+				return false
+			}
+		case p.accept("["):
+			if !p.expr() || !p.accept("]") {
+				return false
+			}
+		default:
+			// a parenthesis here would be a function call
+			return p.peek(0) != "("
+		}
+	}
+}
+
+func (p *exprParser) primary() bool {
+	switch next := p.peek(0); {
+	case isName(next):
+		return p.qualifiedName()
+	case numberLiteral.MatchString(next):
+		p.pos++
+		return true
+	case p.accept("("):
+		return p.expr() && p.accept(")")
+	}
+	return false
+}
+
+// reports whether all expressions in a log message ({expression}) are safe
+func safeLogMessage(msg string) bool {
+	for {
+		start := strings.IndexByte(msg, '{')
+		if start < 0 {
+			return true
+		}
+		end := strings.IndexByte(msg[start:], '}')
+		if end < 0 {
+			return false
+		}
+		if !safeExpression(msg[start+1 : start+end]) {
+			return false
+		}
+		msg = msg[start+end+1:]
+	}
+}
+
+// reports whether name only consists of names separated by ::, like a function
+// other locations (e.g. *address) would be evaluated as expression
+func safeFunctionName(name string) bool {
+	for _, part := range strings.Split(name, "::") {
+		if part == "" || strings.ContainsFunc(part, func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			return false
+		}
+	}
+	return true
+}
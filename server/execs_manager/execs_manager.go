@@ -1,65 +1,334 @@
 /*
 package excsmanager manages the executable files that are created by the server
+
+every executable expires after its time to live, the directory is kept below
+a total size and number of executables by evicting the least recently used ones
+scratch files of runs (like written files or profile data) count towards the limits with their maximum size
 */
 package execsmanager
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/DDP-Projekt/Spielplatz/server/execs_manager/syncmap"
 )
 
 type TokenType int64
 
+type Config struct {
+	Dir string
+	// limits for all executables in Dir, 0 means unlimited
+	MaxBytes int64
+	MaxFiles int
+	// how often expired executables are deleted
+	JanitorInterval time.Duration
+}
+
+// what the manager currently holds
+type Stats struct {
+	Executables int   `json:"executables"`
+	Scratch     int   `json:"scratch"`
+	Bytes       int64 `json:"bytes"` // including the reserved size of the scratch files
+	ActiveRuns  int   `json:"activeRuns"`
+	MaxBytes    int64 `json:"maxBytes"`
+	MaxFiles    int   `json:"maxFiles"`
+	Expired     int   `json:"expired"` // since the start of the server
+	Evicted     int   `json:"evicted"` // since the start of the server
+}
+
+type executable struct {
+	path      string // "" while the program is compiled
+	size      int64  // of the executable and the files that belong to it
+	expires   time.Time
+	last_used time.Time
+	runs      int
+	active    int
+	removed   bool   // the file is deleted when the last active run ended
+	cleanup   func() // called after the file was deleted
+}
+
 var (
 	tokenGenerator = rand.NewSource(time.Now().UnixNano())
-	executables    = syncmap.NewSyncMap[TokenType, string]()
+	config         = Config{Dir: "playground_executables"}
+	executables    = map[TokenType]*executable{}
+	scratch        = map[string]int64{} // the reserved size of the scratch files by path
+	stats          Stats
+	mutex          sync.Mutex // guards everything above
 )
 
+// creates Dir, deletes the files of earlier runs of the server in it and starts the janitor
+func Init(cfg Config) error {
+	mutex.Lock()
+	config = cfg
+	mutex.Unlock()
+
+	if err := os.MkdirAll(cfg.Dir, os.ModePerm); err != nil {
+		return fmt.Errorf("could not create directory for executables: %w", err)
+	}
+	if err := removeOldFiles(cfg.Dir); err != nil {
+		return fmt.Errorf("could not delete old executables: %w", err)
+	}
+	go janitor(cfg.JanitorInterval)
+	return nil
+}
+
+// deletes everything in dir that was created by the server (the names start with Spielplatz_)
+// other files are kept, in case dir is not only used by the server
+func removeOldFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "Spielplatz_") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the directory of the executables
+func Dir() string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return config.Dir
+}
+
+func GetStats() Stats {
+	mutex.Lock()
+	defer mutex.Unlock()
+	s := stats
+	s.Executables, s.Bytes, s.ActiveRuns = 0, 0, 0
+	for _, exe := range executables {
+		if exe.path == "" {
+			continue
+		}
+		s.Executables++
+		s.Bytes += exe.size
+		s.ActiveRuns += exe.active
+	}
+	for _, size := range scratch {
+		s.Scratch++
+		s.Bytes += size
+	}
+	s.MaxBytes, s.MaxFiles = config.MaxBytes, config.MaxFiles
+	return s
+}
+
 func Get(token TokenType) (string, bool) {
-	return executables.Get(token)
+	mutex.Lock()
+	defer mutex.Unlock()
+	exe, ok := executables[token]
+	if !ok {
+		return "", false
+	}
+	return exe.path, true
+}
+
+// adds the compiled executable of token which expires after ttl
+// cleanup is called after the file was deleted, e.g. to delete files that belong to it
+// other executables are evicted if the limits of the directory are exceeded
+func Set(token TokenType, exe_path string, ttl time.Duration, cleanup func()) {
+	now := time.Now()
+	add(token, &executable{
+		path:      exe_path,
+		size:      diskUsage(exe_path),
+		expires:   now.Add(ttl),
+		last_used: now,
+		cleanup:   cleanup,
+	})
+}
+
+// adds exe and evicts other executables if the limits of the directory are exceeded
+func add(token TokenType, exe *executable) {
+	mutex.Lock()
+	executables[token] = exe
+	evicted, _ := evictLocked(exe)
+	mutex.Unlock()
+	removeEvicted(evicted)
+}
+
+// reserves max_bytes for the scratch file or directory at path until RemoveScratch is called
+// other executables are evicted to make room, ErrDirFull is returned if that is not enough
+func ReserveScratch(path string, max_bytes int64) error {
+	mutex.Lock()
+	scratch[path] = max_bytes
+	evicted, over := evictLocked(nil)
+	if over {
+		delete(scratch, path)
+	}
+	mutex.Unlock()
+	removeEvicted(evicted)
+	if over {
+		return ErrDirFull
+	}
+	return nil
 }
 
-func Set(token TokenType, exe_path string) {
-	executables.Set(token, exe_path)
+// deletes the scratch file or directory at path and frees its reservation
+func RemoveScratch(path string) {
+	mutex.Lock()
+	delete(scratch, path)
+	mutex.Unlock()
+	if err := os.RemoveAll(path); err != nil {
+		slog.Warn("failed to delete scratch files", "err", err, "path", path)
+	}
 }
 
+// removes a token for which no executable was created
 func Delete(token TokenType) {
-	executables.Delete(token)
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(executables, token)
 }
 
+// deletes the executable right away, used for executables that are only run once
 func RemoveExecutableFile(token TokenType, exe_path string) {
-	if _, ok := executables.Get(token); ok {
-		slog.Info("deleting executable", "executable", exe_path)
-		if err := os.Remove(exe_path); err != nil {
-			slog.Warn("failed to delete executable", "err", err, "executable", exe_path)
+	mutex.Lock()
+	exe, ok := executables[token]
+	if ok {
+		delete(executables, token)
+	}
+	mutex.Unlock()
+	if ok {
+		cleanup := exe.cleanup
+		if exe.path == "" {
+			cleanup = nil
 		}
-		executables.Delete(token)
+		removeFile(exe_path, cleanup)
 	}
 }
 
 // generates a token and adds it to the executables map
 // returns the token and the path to the executable
 func GenerateExeToken() (TokenType, string) {
+	mutex.Lock()
+	defer mutex.Unlock()
 	for {
 		tok := TokenType(tokenGenerator.Int63())
-		if _, ok := executables.Get(tok); !ok {
-			executables.Set(tok, "")
+		if _, ok := executables[tok]; !ok {
+			executables[tok] = &executable{}
 			return tok, genExePath(tok)
 		}
 	}
 }
 
 func genExePath(token TokenType) string {
-	exe_path := filepath.Join(Exe_Dir, "Spielplatz_"+fmt.Sprint(token))
+	exe_path := filepath.Join(config.Dir, "Spielplatz_"+fmt.Sprint(token))
 	if runtime.GOOS == "windows" {
 		exe_path += ".exe"
 	}
 	return exe_path
 }
+
+// the size of the executable and all files next to it that start with its name and a dot
+// (like the input files or the debug source)
+func diskUsage(exe_path string) int64 {
+	var size int64
+	matches, _ := filepath.Glob(exe_path + ".*")
+	for _, match := range append(matches, exe_path) {
+		filepath.WalkDir(match, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
+
+// removes the least recently used executables (except keep and running ones)
+// until the limits are kept, returns the removed executables and whether the limits are still exceeded
+// running executables and scratch files count towards the limits
+// mutex must be held
+func evictLocked(keep *executable) ([]*executable, bool) {
+	var evicted []*executable
+	for {
+		count, size := len(scratch), int64(0)
+		for _, n := range scratch {
+			size += n
+		}
+		var lru_token TokenType
+		var lru *executable
+		for token, exe := range executables {
+			if exe.path == "" {
+				continue
+			}
+			count++
+			size += exe.size
+			if exe != keep && !exe.removed && exe.active == 0 && (lru == nil || exe.last_used.Before(lru.last_used)) {
+				lru_token, lru = token, exe
+			}
+		}
+		over := (config.MaxFiles > 0 && count > config.MaxFiles) || (config.MaxBytes > 0 && size > config.MaxBytes)
+		if !over || lru == nil {
+			return evicted, over
+		}
+		delete(executables, lru_token)
+		stats.Evicted++
+		evicted = append(evicted, lru)
+	}
+}
+
+func removeEvicted(evicted []*executable) {
+	for _, exe := range evicted {
+		slog.Info("evicted executable", "executable", exe.path)
+		removeFile(exe.path, exe.cleanup)
+	}
+}
+
+// deletes expired executables every interval
+func janitor(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		expire(now)
+	}
+}
+
+// deletes all executables that expired before now
+func expire(now time.Time) {
+	var expired []*executable
+	mutex.Lock()
+	for token, exe := range executables {
+		if exe.path == "" || exe.removed || now.Before(exe.expires) {
+			continue
+		}
+		stats.Expired++
+		// running executables are deleted when their last run ended
+		if exe.active > 0 {
+			exe.removed = true
+			continue
+		}
+		delete(executables, token)
+		expired = append(expired, exe)
+	}
+	mutex.Unlock()
+
+	for _, exe := range expired {
+		slog.Info("executable expired", "executable", exe.path)
+		removeFile(exe.path, exe.cleanup)
+	}
+}
+
+func removeFile(exe_path string, cleanup func()) {
+	slog.Info("deleting executable", "executable", exe_path)
+	if err := os.Remove(exe_path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to delete executable", "err", err, "executable", exe_path)
+	}
+	if cleanup != nil {
+		cleanup()
+	}
+}
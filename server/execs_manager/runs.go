@@ -0,0 +1,73 @@
+package execsmanager
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrTooManyRuns   = errors.New("Das Programm wurde zu oft ausgeführt, bitte neu kompilieren")
+	ErrTooManyActive = errors.New("Das Programm läuft bereits zu oft gleichzeitig")
+	ErrDirFull       = errors.New("Der Server hat gerade keinen Platz für weitere Dateien, bitte später erneut versuchen")
+)
+
+// reserves a run of the executable of token and returns its path
+// a token can be run max_runs times and max_active times at once
+// the executable is not deleted while it runs, release must be called when the run ended
+func AcquireRun(token TokenType, max_runs, max_active int) (exe_path string, release func(), err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	exe, ok := executables[token]
+	switch {
+	case !ok || exe.path == "" || exe.removed:
+		return "", nil, ErrInvalidToken
+	case exe.runs >= max_runs:
+		return "", nil, ErrTooManyRuns
+	case exe.active >= max_active:
+		return "", nil, ErrTooManyActive
+	}
+	exe.runs++
+	exe.active++
+	exe.last_used = time.Now()
+	return exe.path, releaseRun(token, exe), nil
+}
+
+// adds the executable of token like Set with its only run already active,
+// so it is neither expired nor evicted until release is called, which deletes it
+// used for executables that are only run by the request that compiled them
+func SetRunning(token TokenType, exe_path string, cleanup func()) (release func()) {
+	exe := &executable{
+		path:      exe_path,
+		size:      diskUsage(exe_path),
+		last_used: time.Now(),
+		runs:      1,
+		active:    1,
+		removed:   true,
+		cleanup:   cleanup,
+	}
+	add(token, exe)
+	return releaseRun(token, exe)
+}
+
+// ends a run of exe, the file is deleted if it was removed and this was the last active run
+func releaseRun(token TokenType, exe *executable) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mutex.Lock()
+			exe.active--
+			exe.last_used = time.Now()
+			remove := exe.removed && exe.active == 0
+			if remove {
+				delete(executables, token)
+			}
+			mutex.Unlock()
+			if remove {
+				removeFile(exe.path, exe.cleanup)
+			}
+		})
+	}
+}
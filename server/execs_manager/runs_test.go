@@ -0,0 +1,173 @@
+package execsmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireRun(t *testing.T) {
+	assert := assert.New(t)
+
+	exe_path := filepath.Join(t.TempDir(), "exe")
+	assert.NoError(os.WriteFile(exe_path, nil, 0o755))
+	token, _ := GenerateExeToken()
+	_, _, err := AcquireRun(token, 2, 1)
+	assert.ErrorIs(err, ErrInvalidToken, "still compiling")
+	cleaned_up := false
+	Set(token, exe_path, time.Minute, func() { cleaned_up = true })
+
+	path, release, err := AcquireRun(token, 2, 1)
+	assert.NoError(err)
+	assert.Equal(exe_path, path)
+	_, _, err = AcquireRun(token, 2, 1)
+	assert.ErrorIs(err, ErrTooManyActive)
+	release()
+	release()
+
+	_, release, err = AcquireRun(token, 2, 1)
+	assert.NoError(err)
+	_, _, err = AcquireRun(token, 2, 2)
+	assert.ErrorIs(err, ErrTooManyRuns)
+
+	// the file is only deleted after the active run ended
+	expire(time.Now().Add(time.Hour))
+	_, _, err = AcquireRun(token, 5, 5)
+	assert.ErrorIs(err, ErrInvalidToken)
+	assert.FileExists(exe_path)
+	assert.False(cleaned_up)
+	release()
+	assert.NoFileExists(exe_path)
+	assert.True(cleaned_up)
+}
+
+func TestEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	config.MaxFiles, config.MaxBytes = 2, 100
+	t.Cleanup(func() { config.MaxFiles, config.MaxBytes = 0, 0 })
+
+	dir := t.TempDir()
+	add := func(name string, size int) (TokenType, string) {
+		exe_path := filepath.Join(dir, name)
+		assert.NoError(os.WriteFile(exe_path, make([]byte, size), 0o755))
+		token, _ := GenerateExeToken()
+		Set(token, exe_path, time.Minute, nil)
+		return token, exe_path
+	}
+
+	a, a_path := add("a", 10)
+	_, b_path := add("b", 10)
+	// a was used more recently than b
+	_, release, err := AcquireRun(a, 5, 5)
+	assert.NoError(err)
+	release()
+
+	_, c_path := add("c", 10)
+	assert.FileExists(a_path)
+	assert.NoFileExists(b_path)
+	assert.FileExists(c_path)
+
+	// running executables are kept even if the directory is too large
+	_, release, err = AcquireRun(a, 5, 5)
+	assert.NoError(err)
+	defer release()
+	_, d_path := add("d", 90)
+	assert.FileExists(a_path)
+	assert.NoFileExists(c_path)
+	assert.FileExists(d_path)
+}
+
+// starts the test without executables and scratch files and with the given limits
+func resetState(t *testing.T, max_files int, max_bytes int64) {
+	mutex.Lock()
+	executables, scratch = map[TokenType]*executable{}, map[string]int64{}
+	config.MaxFiles, config.MaxBytes = max_files, max_bytes
+	mutex.Unlock()
+	t.Cleanup(func() { config.MaxFiles, config.MaxBytes = 0, 0 })
+}
+
+func TestSetRunning(t *testing.T) {
+	assert := assert.New(t)
+	resetState(t, 1, 0)
+
+	dir := t.TempDir()
+	exe_path := filepath.Join(dir, "exe")
+	assert.NoError(os.WriteFile(exe_path, nil, 0o755))
+	token, _ := GenerateExeToken()
+	cleaned_up := false
+	release := SetRunning(token, exe_path, func() { cleaned_up = true })
+
+	// the executable can not be run again, expired or evicted
+	_, _, err := AcquireRun(token, 5, 5)
+	assert.ErrorIs(err, ErrInvalidToken)
+	expire(time.Now().Add(time.Hour))
+	other_path := filepath.Join(dir, "other")
+	assert.NoError(os.WriteFile(other_path, nil, 0o755))
+	other, _ := GenerateExeToken()
+	Set(other, other_path, time.Minute, nil)
+	assert.FileExists(exe_path)
+	assert.Equal(2, GetStats().Executables)
+
+	release()
+	release()
+	assert.NoFileExists(exe_path)
+	assert.True(cleaned_up)
+	assert.Equal(1, GetStats().Executables)
+}
+
+func TestReserveScratch(t *testing.T) {
+	assert := assert.New(t)
+	resetState(t, 3, 100)
+
+	dir := t.TempDir()
+	exe_path := filepath.Join(dir, "exe")
+	assert.NoError(os.WriteFile(exe_path, make([]byte, 50), 0o755))
+	token, _ := GenerateExeToken()
+	Set(token, exe_path, time.Minute, nil)
+
+	// the reservations count towards the limits and evict executables
+	scratch_dir := filepath.Join(dir, "Spielplatz_run_1")
+	assert.NoError(os.Mkdir(scratch_dir, 0o755))
+	assert.NoError(ReserveScratch(scratch_dir, 40))
+	assert.FileExists(exe_path)
+	stats := GetStats()
+	assert.Equal(1, stats.Scratch)
+	assert.Equal(int64(90), stats.Bytes)
+
+	data_file := filepath.Join(dir, "Spielplatz_1.perf")
+	assert.NoError(os.WriteFile(data_file, nil, 0o644))
+	assert.NoError(ReserveScratch(data_file, 60))
+	assert.NoFileExists(exe_path)
+
+	// more than fits even without executables
+	assert.ErrorIs(ReserveScratch(filepath.Join(dir, "Spielplatz_2.perf"), 1), ErrDirFull)
+	assert.Equal(2, GetStats().Scratch)
+
+	RemoveScratch(scratch_dir)
+	RemoveScratch(data_file)
+	assert.NoDirExists(scratch_dir)
+	assert.NoFileExists(data_file)
+	stats = GetStats()
+	assert.Equal(0, stats.Scratch)
+	assert.Equal(int64(0), stats.Bytes)
+}
+
+func TestRemoveOldFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(dir, "Spielplatz_1"), nil, 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "Spielplatz_1.ddp"), nil, 0o644))
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "Spielplatz_run_1", "ordner"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "wichtig.txt"), nil, 0o644))
+
+	assert.NoError(removeOldFiles(dir))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(err)
+	assert.Len(entries, 1)
+	assert.Equal("wichtig.txt", entries[0].Name())
+}
@@ -1,35 +0,0 @@
-package syncmap
-
-import "sync"
-
-// a thread-safe map
-type SyncMap[K comparable, V any] struct {
-	m  map[K]V
-	mu *sync.RWMutex
-}
-
-func NewSyncMap[K comparable, V any]() *SyncMap[K, V] {
-	return &SyncMap[K, V]{
-		m:  make(map[K]V),
-		mu: &sync.RWMutex{},
-	}
-}
-
-func (m *SyncMap[K, V]) Get(key K) (V, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	v, ok := m.m[key]
-	return v, ok
-}
-
-func (m *SyncMap[K, V]) Set(key K, value V) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.m[key] = value
-}
-
-func (m *SyncMap[K, V]) Delete(key K) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	delete(m.m, key)
-}
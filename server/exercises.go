@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	"github.com/DDP-Projekt/Spielplatz/server/exercises"
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	testrunner "github.com/DDP-Projekt/Spielplatz/server/test_runner"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+var exerciseCatalogue *exercises.Catalogue
+
+// only submissions to a room are stored, so that the teacher of the room can see them
+const createExerciseSubmissionsTableSQL = `
+CREATE TABLE IF NOT EXISTS exercise_submissions (
+	uuid TEXT PRIMARY KEY,
+	exercise_id TEXT NOT NULL,
+	compressed_code BLOB NOT NULL,
+	passed INTEGER NOT NULL,
+	total INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// loads the exercises from dir and creates the submissions table
+// must be called after initShareLinksStorage
+func initExercises(dir string) error {
+	catalogue, err := exercises.Load(dir)
+	if err != nil {
+		return err
+	}
+	if err := createExerciseSubmissionsTable(); err != nil {
+		return err
+	}
+	exerciseCatalogue = catalogue
+	slog.Info("loaded exercises", "count", len(catalogue.All()), "dir", dir)
+	return nil
+}
+
+func createExerciseSubmissionsTable() error {
+	if _, err := shareLinksDB.Exec(createExerciseSubmissionsTableSQL); err != nil {
+		return err
+	}
+	// older versions stored submissions without a room
+	if err := addColumnIfMissing("exercise_submissions", "room_uuid", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("exercise_submissions", "student_name", "TEXT"); err != nil {
+		return err
+	}
+	_, err := shareLinksDB.Exec("CREATE INDEX IF NOT EXISTS exercise_submissions_room ON exercise_submissions (room_uuid, created_at)")
+	return err
+}
+
+type ExerciseSubmission struct {
+	ID          string    `json:"id"`
+	ExerciseID  string    `json:"exerciseId"`
+	StudentName string    `json:"studentName"`
+	Code        string    `json:"code"`
+	Passed      int       `json:"passed"`
+	Total       int       `json:"total"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// stores the submission unless the room already has max_submissions exercise submissions, then errRoomFull is returned
+func storeExerciseSubmission(room_id string, max_submissions int, submission ExerciseSubmission) error {
+	// counting and inserting in one statement, like storeRoomSubmission
+	res, err := shareLinksDB.Exec(
+		`INSERT INTO exercise_submissions (uuid, exercise_id, compressed_code, passed, total, created_at, room_uuid, student_name)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ? WHERE (SELECT COUNT(*) FROM exercise_submissions WHERE room_uuid = ?) < ?`,
+		submission.ID, submission.ExerciseID, zstdEncoder.EncodeAll([]byte(submission.Code), nil), submission.Passed, submission.Total,
+		submission.CreatedAt, room_id, submission.StudentName,
+		room_id, max_submissions,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errRoomFull
+	}
+	return nil
+}
+
+// all exercise submissions of a room, oldest first
+func getExerciseSubmissions(room_id string) ([]ExerciseSubmission, error) {
+	rows, err := shareLinksDB.Query(
+		"SELECT uuid, exercise_id, student_name, compressed_code, passed, total, created_at FROM exercise_submissions WHERE room_uuid = ? ORDER BY created_at",
+		room_id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	submissions := []ExerciseSubmission{}
+	for rows.Next() {
+		var (
+			submission      ExerciseSubmission
+			compressed_code []byte
+		)
+		if err := rows.Scan(&submission.ID, &submission.ExerciseID, &submission.StudentName, &compressed_code,
+			&submission.Passed, &submission.Total, &submission.CreatedAt); err != nil {
+			return nil, err
+		}
+		code, err := zstdDecoder.DecodeAll(compressed_code, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing exercise submission %s: %w", submission.ID, err)
+		}
+		submission.Code = string(code)
+		submissions = append(submissions, submission)
+	}
+	return submissions, rows.Err()
+}
+
+type ExerciseSummary struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type ExerciseDetails struct {
+	ExerciseSummary
+	Description string            `json:"description"`
+	StarterCode string            `json:"starterCode"`
+	Examples    []testrunner.Case `json:"examples"`
+	HiddenTests int               `json:"hiddenTests"`
+}
+
+// serves the /exercises endpoint
+func serve_list_exercises(c *gin.Context) {
+	all := exerciseCatalogue.All()
+	result := make([]ExerciseSummary, 0, len(all))
+	for _, e := range all {
+		result = append(result, ExerciseSummary{ID: e.ID, Title: e.Title})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// serves the /exercises/:id endpoint
+func serve_get_exercise(c *gin.Context) {
+	exercise, ok := exerciseCatalogue.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unbekannte Aufgabe"})
+		return
+	}
+	c.JSON(http.StatusOK, ExerciseDetails{
+		ExerciseSummary: ExerciseSummary{ID: exercise.ID, Title: exercise.Title},
+		Description:     exercise.Description,
+		StarterCode:     exercise.StarterCode,
+		Examples:        exercise.Examples,
+		HiddenTests:     len(exercise.Hidden),
+	})
+}
+
+type SubmitExerciseRequest struct {
+	Src string `json:"src"`
+	// optional, the submission is only stored for the teacher of the room if both are set
+	JoinCode    string `json:"joinCode"`
+	StudentName string `json:"studentName"`
+}
+
+// result of a hidden test, without any output that could reveal the test
+type HiddenTestResult struct {
+	Passed bool `json:"passed"`
+}
+
+type SubmitExerciseResponse struct {
+	SubmissionID string                                    `json:"submissionId,omitempty"`
+	Compilation  kddp.ProgramResult[executables.TokenType] `json:"compilation"`
+	Examples     []testrunner.Result                       `json:"examples"`
+	Hidden       []HiddenTestResult                        `json:"hidden"`
+	Passed       int                                       `json:"passed"`
+	Total        int                                       `json:"total"`
+	Score        float64                                   `json:"score"` // between 0 and 1
+}
+
+// serves the /exercises/:id/submit endpoint
+// runs all tests of the exercise and stores the score in the room of the join code
+func serve_submit_exercise(c *gin.Context) {
+	logger := getLogger(c)
+
+	exercise, ok := exerciseCatalogue.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unbekannte Aufgabe"})
+		return
+	}
+	logger = logger.With("exercise", exercise.ID)
+
+	var req SubmitExerciseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("unmarshaling request", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the room is checked before grading, so that a wrong join code does not cost a compilation
+	var room *Room
+	if req.JoinCode != "" || req.StudentName != "" {
+		req.StudentName = strings.TrimSpace(req.StudentName)
+		if !validStudentName(c, req.StudentName) {
+			return
+		}
+		r, ok := joinRoom(c, req.JoinCode)
+		if !ok {
+			return
+		}
+		room = &r
+		logger = logger.With("room", room.ID)
+	}
+
+	cases := exercise.AllCases()
+	results, compilation, ok := compileAndRunCases(c, logger, req.Src, cases)
+	if !ok {
+		return
+	}
+
+	resp := SubmitExerciseResponse{
+		Compilation: compilation,
+		Passed:      testrunner.Passed(results),
+		Total:       len(cases),
+	}
+	if compilation.Error == nil {
+		resp.Examples = results[:len(exercise.Examples)]
+		for _, r := range results[len(exercise.Examples):] {
+			resp.Hidden = append(resp.Hidden, HiddenTestResult{Passed: r.Passed})
+		}
+	}
+	resp.Score = float64(resp.Passed) / float64(resp.Total)
+
+	if room != nil {
+		submission := ExerciseSubmission{
+			ID:          uuid.NewString(),
+			ExerciseID:  exercise.ID,
+			StudentName: req.StudentName,
+			Code:        req.Src,
+			Passed:      resp.Passed,
+			Total:       resp.Total,
+			CreatedAt:   time.Now().UTC(),
+		}
+		switch err := storeExerciseSubmission(room.ID, viper.GetInt("max_room_submissions"), submission); err {
+		case nil:
+			resp.SubmissionID = submission.ID
+		case errRoomFull:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Der Raum nimmt keine weiteren Abgaben an"})
+			return
+		default:
+			logger.Error("failed to store submission", "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store submission"})
+			return
+		}
+	}
+
+	logger.Info("graded submission", "passed", resp.Passed, "total", resp.Total)
+	c.JSON(http.StatusOK, resp)
+}
+
+// serves the GET /rooms/:id/exercises endpoint
+func serve_room_exercise_submissions(c *gin.Context) {
+	room, ok := authorizeTeacher(c)
+	if !ok {
+		return
+	}
+
+	submissions, err := getExerciseSubmissions(room.ID)
+	if err != nil {
+		getLogger(c).Error("failed to load exercise submissions", "err", err, "room", room.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load submissions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"room":        room,
+		"submissions": submissions,
+	})
+}
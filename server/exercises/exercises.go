@@ -0,0 +1,141 @@
+/*
+package exercises loads the exercise catalogue from a directory
+
+every exercise is a subdirectory whose name is the exercise id and which contains
+  - exercise.json: title, public example tests and hidden tests
+  - description.md: the task description (optional)
+  - starter.ddp: the starter code (optional)
+*/
+package exercises
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	testrunner "github.com/DDP-Projekt/Spielplatz/server/test_runner"
+)
+
+const (
+	metaFile        = "exercise.json"
+	descriptionFile = "description.md"
+	starterFile     = "starter.ddp"
+)
+
+type Exercise struct {
+	ID          string
+	Title       string
+	Description string
+	StarterCode string
+	// shown to the students
+	Examples []testrunner.Case
+	// only used for grading
+	Hidden []testrunner.Case
+}
+
+// all test cases used for grading, examples first
+func (e *Exercise) AllCases() []testrunner.Case {
+	return slices.Concat(e.Examples, e.Hidden)
+}
+
+type exerciseMeta struct {
+	Title    string            `json:"title"`
+	Examples []testrunner.Case `json:"examples"`
+	Hidden   []testrunner.Case `json:"hidden"`
+}
+
+// a loaded set of exercises
+type Catalogue struct {
+	exercises map[string]*Exercise
+	ids       []string // sorted
+}
+
+// loads every exercise in dir
+// a missing dir results in an empty catalogue
+func Load(dir string) (*Catalogue, error) {
+	catalogue := &Catalogue{exercises: make(map[string]*Exercise)}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return catalogue, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		exercise, err := loadExercise(filepath.Join(dir, entry.Name()), entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("loading exercise %s: %w", entry.Name(), err)
+		}
+		catalogue.exercises[exercise.ID] = exercise
+		catalogue.ids = append(catalogue.ids, exercise.ID)
+	}
+	slices.Sort(catalogue.ids)
+	return catalogue, nil
+}
+
+func loadExercise(dir, id string) (*Exercise, error) {
+	meta_bytes, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if err != nil {
+		return nil, err
+	}
+	var meta exerciseMeta
+	if err := json.Unmarshal(meta_bytes, &meta); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", metaFile, err)
+	}
+	if meta.Title == "" {
+		return nil, fmt.Errorf("%s has no title", metaFile)
+	}
+	if len(meta.Examples)+len(meta.Hidden) == 0 {
+		return nil, errors.New("exercise has no tests")
+	}
+
+	description, err := readOptional(filepath.Join(dir, descriptionFile))
+	if err != nil {
+		return nil, err
+	}
+	starter, err := readOptional(filepath.Join(dir, starterFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exercise{
+		ID:          id,
+		Title:       meta.Title,
+		Description: description,
+		StarterCode: starter,
+		Examples:    meta.Examples,
+		Hidden:      meta.Hidden,
+	}, nil
+}
+
+func readOptional(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+func (c *Catalogue) Get(id string) (*Exercise, bool) {
+	e, ok := c.exercises[id]
+	return e, ok
+}
+
+// all exercises sorted by id
+func (c *Catalogue) All() []*Exercise {
+	result := make([]*Exercise, 0, len(c.ids))
+	for _, id := range c.ids {
+		result = append(result, c.exercises[id])
+	}
+	return result
+}
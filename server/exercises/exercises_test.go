@@ -0,0 +1,47 @@
+package exercises
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(dir, path)
+		assert.NoError(os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(os.WriteFile(path, []byte(content), 0o644))
+	}
+	write("b/exercise.json", `{"title": "B", "hidden": [{"stdin": "1"}]}`)
+	write("a/exercise.json", `{"title": "A", "examples": [{"expectedStdout": "x"}], "hidden": [{"args": ["y"]}]}`)
+	write("a/description.md", "Beschreibung")
+	write("a/starter.ddp", "Schreibe \"x\".")
+	write(".git/config", "")
+
+	catalogue, err := Load(dir)
+	assert.NoError(err)
+
+	all := catalogue.All()
+	assert.Len(all, 2)
+	assert.Equal("a", all[0].ID)
+	assert.Equal("b", all[1].ID)
+
+	a, ok := catalogue.Get("a")
+	assert.True(ok)
+	assert.Equal("Beschreibung", a.Description)
+	assert.Equal("Schreibe \"x\".", a.StarterCode)
+	assert.Len(a.AllCases(), 2)
+
+	write("c/exercise.json", `{"title": "C"}`)
+	_, err = Load(dir)
+	assert.Error(err)
+
+	catalogue, err = Load(filepath.Join(dir, "missing"))
+	assert.NoError(err)
+	assert.Empty(catalogue.All())
+}
@@ -0,0 +1,175 @@
+/*
+package fanout sends the same messages to many read-only websocket connections,
+like the viewers of a broadcast or the spectators of a run
+*/
+package fanout
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrFull   = errors.New("too many connections")
+	ErrClosed = errors.New("the group is closed")
+)
+
+// a connection of a Group with some data of its owner, e.g. which messages it wants
+type Conn[T any] struct {
+	ws   *websocket.Conn
+	send chan *websocket.PreparedMessage
+	Data T
+}
+
+// a set of read-only connections
+// all methods except Serve must be called with the mutex of the group held,
+// so that the owner can send its state and messages consistently
+type Group[T any] struct {
+	mu     sync.Locker
+	conns  map[*Conn[T]]struct{}
+	closed bool
+}
+
+// a group guarded by mu
+func New[T any](mu sync.Locker) *Group[T] {
+	return &Group[T]{
+		mu:    mu,
+		conns: make(map[*Conn[T]]struct{}),
+	}
+}
+
+// serves ws until it is closed or removed from the group
+// join is called with the mutex held right after ws was added, e.g. to send the current state
+// messages are queued in a buffer of send_buffer messages, slower connections are closed
+func (g *Group[T]) Serve(ws *websocket.Conn, data T, max_conns, send_buffer int, join func(c *Conn[T]), logger *slog.Logger) error {
+	c := &Conn[T]{
+		ws:   ws,
+		send: make(chan *websocket.PreparedMessage, send_buffer),
+		Data: data,
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return ErrClosed
+	}
+	if len(g.conns) >= max_conns {
+		g.mu.Unlock()
+		return ErrFull
+	}
+	g.conns[c] = struct{}{}
+	if join != nil {
+		join(c)
+	}
+	g.mu.Unlock()
+
+	writer_done := make(chan struct{})
+	go func() {
+		defer close(writer_done)
+		// the connection ends when it was removed from the group
+		defer ws.Close()
+		for msg := range c.send {
+			ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := ws.WritePreparedMessage(msg); err != nil {
+				logger.Info("failed to write to read-only connection", "err", err)
+				ws.Close()
+				for range c.send {
+				}
+				return
+			}
+		}
+	}()
+
+	// the connections are only read to notice when they are closed
+	for {
+		if _, _, err := ws.NextReader(); err != nil {
+			break
+		}
+	}
+
+	g.mu.Lock()
+	g.remove(c)
+	g.mu.Unlock()
+	<-writer_done
+	return nil
+}
+
+// the number of connections
+func (g *Group[T]) Len() int {
+	return len(g.conns)
+}
+
+func (g *Group[T]) remove(c *Conn[T]) {
+	if _, ok := g.conns[c]; !ok {
+		return
+	}
+	delete(g.conns, c)
+	close(c.send)
+}
+
+// encodes msg as json once and queues it for every connection for which filter returns true
+// a nil filter sends msg to all connections
+func (g *Group[T]) Broadcast(msg any, filter func(data T) bool) {
+	if len(g.conns) == 0 {
+		return
+	}
+	prepared, err := prepare(msg)
+	if err != nil {
+		slog.Error("failed to prepare fan-out message", "err", err)
+		return
+	}
+	for c := range g.conns {
+		if filter == nil || filter(c.Data) {
+			g.queue(c, prepared)
+		}
+	}
+}
+
+// encodes msg as json and queues it for c only
+func (g *Group[T]) Send(c *Conn[T], msg any) {
+	prepared, err := prepare(msg)
+	if err != nil {
+		slog.Error("failed to prepare fan-out message", "err", err)
+		return
+	}
+	g.queue(c, prepared)
+}
+
+// queues msg without blocking, slow connections are closed
+// and have to reconnect to get the current state
+func (g *Group[T]) queue(c *Conn[T], msg *websocket.PreparedMessage) {
+	select {
+	case c.send <- msg:
+	default:
+		c.ws.Close()
+		g.remove(c)
+	}
+}
+
+// sends a close frame to all connections, removes them and stops accepting new ones
+func (g *Group[T]) Close(code int, reason string) {
+	if g.closed {
+		return
+	}
+	g.closed = true
+	prepared, err := websocket.NewPreparedMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	for c := range g.conns {
+		if err == nil {
+			g.queue(c, prepared)
+		}
+		g.remove(c)
+	}
+}
+
+func prepare(msg any) (*websocket.PreparedMessage, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NewPreparedMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,125 @@
+package fanout
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a server that serves every websocket connection with serve
+func wsServer(t *testing.T, serve func(ws *websocket.Conn)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{Subprotocols: []string{"a", "b", "c", "d"}}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		serve(ws)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// connects to url, name ends up as the data of the connection
+func connect(t *testing.T, url, name string) *websocket.Conn {
+	con, _, err := (&websocket.Dialer{Subprotocols: []string{name}}).Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { con.Close() })
+	return con
+}
+
+func readText(t *testing.T, con *websocket.Conn) string {
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := con.ReadMessage()
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestServe(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	g := New[string](&mu)
+
+	errs := make(chan error, 4)
+	url := wsServer(t, func(ws *websocket.Conn) {
+		errs <- g.Serve(ws, ws.Subprotocol(), 2, 4, func(c *Conn[string]) {
+			g.Send(c, "hallo "+c.Data)
+		}, slog.Default())
+	})
+
+	a := connect(t, url, "a")
+	assert.Equal(`"hallo a"`, readText(t, a))
+	b := connect(t, url, "b")
+	assert.Equal(`"hallo b"`, readText(t, b))
+	connect(t, url, "c")
+	assert.ErrorIs(<-errs, ErrFull)
+
+	mu.Lock()
+	assert.Equal(2, g.Len())
+	g.Broadcast("alle", nil)
+	g.Broadcast("nur b", func(data string) bool { return data == "b" })
+	mu.Unlock()
+	assert.Equal(`"alle"`, readText(t, a))
+	assert.Equal(`"alle"`, readText(t, b))
+	assert.Equal(`"nur b"`, readText(t, b))
+
+	// connections that close are removed
+	a.Close()
+	assert.NoError(<-errs)
+	mu.Lock()
+	assert.Equal(1, g.Len())
+	g.Close(websocket.CloseNormalClosure, "vorbei")
+	assert.Equal(0, g.Len())
+	mu.Unlock()
+
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := b.ReadMessage()
+	assert.True(websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	assert.NoError(<-errs)
+
+	// closed groups take no new connections
+	connect(t, url, "d")
+	assert.ErrorIs(<-errs, ErrClosed)
+}
+
+func TestSlowConnectionIsClosed(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	g := New[struct{}](&mu)
+
+	// the connection is added directly, so that nothing drains its queue
+	conns := make(chan *websocket.Conn)
+	done := make(chan struct{})
+	url := wsServer(t, func(ws *websocket.Conn) {
+		conns <- ws
+		<-done
+	})
+	client := connect(t, url, "a")
+	c := &Conn[struct{}]{ws: <-conns, send: make(chan *websocket.PreparedMessage, 1)}
+	defer close(done)
+
+	mu.Lock()
+	g.conns[c] = struct{}{}
+	g.Broadcast("eins", nil)
+	assert.Equal(1, g.Len())
+	g.Broadcast("zwei", nil)
+	assert.Equal(0, g.Len())
+	mu.Unlock()
+
+	// the queued message is still there, then the queue is closed
+	_, ok := <-c.send
+	assert.True(ok)
+	_, ok = <-c.send
+	assert.False(ok)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := client.ReadMessage()
+	assert.Error(err)
+}
@@ -0,0 +1,217 @@
+/*
+package graphics validates the drawing commands a program writes to its graphics channel
+
+every line is one command, e.g. "linie 0 0 100 50" or "farbe rot"
+*/
+package graphics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the largest absolute value of a coordinate or size
+const maxCoordinate = 100000
+
+// a validated drawing command as sent to the client
+type Command struct {
+	Type  string    `json:"type"`
+	Args  []float64 `json:"args,omitempty"`
+	Color string    `json:"color,omitempty"`
+	Text  string    `json:"text,omitempty"`
+}
+
+type Limits struct {
+	MaxCommands   int // per run
+	MaxLineLength int // in bytes
+	MaxCanvasSize int // in pixels per side
+}
+
+type command struct {
+	typ   string
+	nargs int
+	sizes int // the last sizes arguments must not be negative
+}
+
+// the commands understood in the graphics channel
+var commands = map[string]command{
+	"leinwand":    {"canvas", 2, 2}, // breite höhe
+	"leeren":      {"clear", 0, 0},
+	"farbe":       {"color", 0, 0}, // farbe
+	"füllfarbe":   {"fill", 0, 0},  // farbe oder "keine"
+	"stiftbreite": {"lineWidth", 1, 1},
+	"punkt":       {"point", 2, 0},  // x y
+	"linie":       {"line", 4, 0},   // x1 y1 x2 y2
+	"rechteck":    {"rect", 4, 2},   // x y breite höhe
+	"kreis":       {"circle", 3, 1}, // x y radius
+	"text":        {"text", 2, 0},   // x y text
+}
+
+var colorNames = map[string]string{
+	"schwarz": "#000000",
+	"weiß":    "#ffffff",
+	"grau":    "#808080",
+	"rot":     "#ff0000",
+	"grün":    "#008000",
+	"blau":    "#0000ff",
+	"gelb":    "#ffff00",
+	"orange":  "#ffa500",
+	"lila":    "#800080",
+	"braun":   "#8b4513",
+}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// parses and validates a single line
+func Parse(line string, limits Limits) (Command, error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	cmd, ok := commands[name]
+	if !ok {
+		return Command{}, fmt.Errorf("unbekannter Befehl %q", name)
+	}
+	result := Command{Type: cmd.typ}
+
+	fields := strings.Fields(rest)
+	if cmd.typ == "text" {
+		// the text is everything after the coordinates
+		fields = strings.SplitN(strings.TrimSpace(rest), " ", 3)
+		if len(fields) < 3 {
+			return Command{}, fmt.Errorf("%s erwartet x, y und einen Text", name)
+		}
+		result.Text = fields[2]
+		fields = fields[:2]
+	}
+
+	switch cmd.typ {
+	case "color", "fill":
+		if len(fields) != 1 {
+			return Command{}, fmt.Errorf("%s erwartet eine Farbe", name)
+		}
+		if cmd.typ == "fill" && fields[0] == "keine" {
+			return result, nil
+		}
+		color, err := parseColor(fields[0])
+		if err != nil {
+			return Command{}, err
+		}
+		result.Color = color
+		return result, nil
+	}
+
+	if len(fields) != cmd.nargs {
+		return Command{}, fmt.Errorf("%s erwartet %d Zahlen", name, cmd.nargs)
+	}
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(strings.Replace(f, ",", ".", 1), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > maxCoordinate {
+			return Command{}, fmt.Errorf("%s: ungültige Zahl %q", name, f)
+		}
+		result.Args = append(result.Args, v)
+	}
+
+	for _, v := range result.Args[cmd.nargs-cmd.sizes:] {
+		if v < 0 {
+			return Command{}, fmt.Errorf("%s: negative Größen sind nicht erlaubt", name)
+		}
+		if cmd.typ == "canvas" && (v < 1 || v > float64(limits.MaxCanvasSize)) {
+			return Command{}, fmt.Errorf("die Leinwand muss zwischen 1 und %d Pixel groß sein", limits.MaxCanvasSize)
+		}
+	}
+	return result, nil
+}
+
+func parseColor(s string) (string, error) {
+	if color, ok := colorNames[s]; ok {
+		return color, nil
+	}
+	if hexColor.MatchString(s) {
+		return strings.ToLower(s), nil
+	}
+	return "", fmt.Errorf("unbekannte Farbe %q", s)
+}
+
+// splits the written data into lines and passes every valid command to emit
+// invalid lines are reported to report_error
+// after MaxCommands lines everything else is discarded
+type Writer struct {
+	limits       Limits
+	emit         func(Command) error
+	report_error func(string)
+	buf          []byte
+	count        int
+	line         int
+	skip_line    bool // the current line is too long and is ignored up to its end
+}
+
+func NewWriter(limits Limits, emit func(Command) error, report_error func(string)) *Writer {
+	return &Writer{limits: limits, emit: emit, report_error: report_error}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.append(p)
+			break
+		}
+		w.append(p[:i])
+		p = p[i+1:]
+		if err := w.endLine(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// ends the last line if it has no trailing newline
+func (w *Writer) Close() error {
+	if len(w.buf) > 0 || w.skip_line {
+		return w.endLine()
+	}
+	return nil
+}
+
+func (w *Writer) append(p []byte) {
+	if w.skip_line {
+		return
+	}
+	if len(w.buf)+len(p) > w.limits.MaxLineLength {
+		w.skip_line = true
+		w.buf = w.buf[:0]
+		return
+	}
+	w.buf = append(w.buf, p...)
+}
+
+func (w *Writer) endLine() error {
+	w.line++
+	line, too_long := string(w.buf), w.skip_line
+	w.buf, w.skip_line = w.buf[:0], false
+
+	if !too_long && strings.TrimSpace(line) == "" {
+		return nil
+	}
+	// invalid lines count as well, so that they cannot flood the client with errors
+	w.count++
+	switch {
+	case w.count > w.limits.MaxCommands+1:
+		return nil
+	case w.count > w.limits.MaxCommands:
+		w.report_error(fmt.Sprintf("Grafik: mehr als %d Befehle, alle weiteren werden ignoriert\n", w.limits.MaxCommands))
+		return nil
+	case too_long:
+		w.report_error(fmt.Sprintf("Grafik, Zeile %d: die Zeile ist länger als %d Bytes\n", w.line, w.limits.MaxLineLength))
+		return nil
+	}
+	cmd, err := Parse(line, w.limits)
+	if err != nil {
+		w.report_error(fmt.Sprintf("Grafik, Zeile %d: %s\n", w.line, err))
+		return nil
+	}
+	return w.emit(cmd)
+}
@@ -0,0 +1,68 @@
+package graphics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLimits = Limits{MaxCommands: 3, MaxLineLength: 20, MaxCanvasSize: 500}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd, err := Parse("linie 0 0 100 50,5", testLimits)
+	assert.NoError(err)
+	assert.Equal(Command{Type: "line", Args: []float64{0, 0, 100, 50.5}}, cmd)
+
+	cmd, err = Parse("farbe rot", testLimits)
+	assert.NoError(err)
+	assert.Equal("#ff0000", cmd.Color)
+
+	cmd, err = Parse("füllfarbe keine", testLimits)
+	assert.NoError(err)
+	assert.Equal(Command{Type: "fill"}, cmd)
+
+	cmd, err = Parse("text 10 20 Hallo Welt", testLimits)
+	assert.NoError(err)
+	assert.Equal(Command{Type: "text", Args: []float64{10, 20}, Text: "Hallo Welt"}, cmd)
+
+	for _, line := range []string{
+		"malen 1 2",
+		"linie 0 0 1",
+		"kreis 0 0 -1",
+		"punkt NaN 0",
+		"punkt 1e9 0",
+		"leinwand 501 100",
+		"farbe #12345",
+		"text 1 2",
+	} {
+		_, err := Parse(line, testLimits)
+		assert.Error(err, line)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	var cmds []Command
+	var errs []string
+	w := NewWriter(testLimits, func(c Command) error {
+		cmds = append(cmds, c)
+		return nil
+	}, func(s string) {
+		errs = append(errs, s)
+	})
+
+	w.Write([]byte("leer"))
+	w.Write([]byte("en\n\nkreis 1 1 1 1\npunkt 1 1 aaaaaaaaaaaaaaaaaaaa\n"))
+	w.Write([]byte("punkt 1 2\npunkt 3 4\n"))
+	w.Close()
+
+	assert.Equal([]Command{{Type: "clear"}}, cmds)
+	assert.Equal([]string{
+		"Grafik, Zeile 3: kreis erwartet 3 Zahlen\n",
+		"Grafik, Zeile 4: die Zeile ist länger als 20 Bytes\n",
+		"Grafik: mehr als 3 Befehle, alle weiteren werden ignoriert\n",
+	}, errs)
+}
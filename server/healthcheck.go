@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
 	"github.com/DDP-Projekt/Spielplatz/server/kddp"
 	"github.com/gin-gonic/gin"
 )
@@ -38,12 +39,14 @@ type KddpHealthcheckResult struct {
 }
 
 type HealthcheckResult struct {
-	Healthy    bool                  `json:"healthy"`
-	KddpStatus KddpHealthcheckResult `json:"kddp-status"`
+	Healthy     bool                  `json:"healthy"`
+	KddpStatus  KddpHealthcheckResult `json:"kddp-status"`
+	Executables executables.Stats     `json:"executables"`
 }
 
 func performHealthcheck(logger *slog.Logger) (result HealthcheckResult) {
 	result.Healthy = true
+	result.Executables = executables.GetStats()
 
 	if kddpResult, err := kddp.GetKDDPVersion(); err != nil {
 		logger.Error("could not read kddp version", "err", err.Error())
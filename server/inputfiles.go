@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var errInputFilesTooLarge = errors.New("Die Eingabedateien sind zu groß")
+
+// the directory with the input files of an executable
+func inputDir(exe_path string) string {
+	return exe_path + ".files"
+}
+
+// checks the number, names and total size of the input files of a compile request
+func validateInputFiles(files map[string]string) error {
+	if len(files) > viper.GetInt("max_input_files") {
+		return fmt.Errorf("Es sind höchstens %d Eingabedateien erlaubt", viper.GetInt("max_input_files"))
+	}
+	size := 0
+	for name, content := range files {
+		// only plain names, the program must not be able to refer to anything outside the directory
+		if name == "" || name == "." || name == ".." || len(name) > 100 || strings.ContainsAny(name, "/\\\x00") {
+			return fmt.Errorf("Ungültiger Dateiname %q", name)
+		}
+		size += len(content)
+	}
+	if size > viper.GetInt("max_input_files_bytes") {
+		return errInputFilesTooLarge
+	}
+	return nil
+}
+
+// writes the input files next to the executable, they are only ever read by the program
+func writeInputFiles(exe_path string, files map[string]string) error {
+	dir := inputDir(exe_path)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating input directory: %w", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o444); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("error writing input file: %w", err)
+		}
+	}
+	return nil
+}
+
+// returns the input directory of the executable, "" if it has no input files
+func inputFiles(exe_path string) string {
+	dir := inputDir(exe_path)
+	if _, err := os.Stat(dir); err != nil {
+		return ""
+	}
+	return dir
+}
+
+func removeInputFiles(exe_path string, logger *slog.Logger) {
+	if err := os.RemoveAll(inputDir(exe_path)); err != nil {
+		logger.Warn("failed to delete input files", "err", err)
+	}
+}
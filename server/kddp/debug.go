@@ -0,0 +1,140 @@
+package kddp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	procqueue "github.com/DDP-Projekt/Spielplatz/server/proc_queue"
+	"github.com/spf13/viper"
+)
+
+// the path of the DDP source file that belongs to a debug executable
+// it is kept next to the executable because the debug info refers to it
+func DebugSourcePath(exe_path string) string {
+	return exe_path + ".ddp"
+}
+
+// like CompileDDPProgram, but the executable contains debug info for gdb
+// the source is written to DebugSourcePath(exe_path), which the caller has to delete
+func CompileDebugProgram[TokenType tokenType](ctx context.Context, client procqueue.Client, src io.Reader, token TokenType, exe_path string, logger *slog.Logger) (ProgramResult[TokenType], string, error) {
+	src_path, err := filepath.Abs(DebugSourcePath(exe_path))
+	if err != nil {
+		return ProgramResult[TokenType]{}, "", err
+	}
+	src_file, err := os.Create(src_path)
+	if err != nil {
+		return ProgramResult[TokenType]{}, "", fmt.Errorf("error creating source file: %w", err)
+	}
+	_, err = io.Copy(src_file, src)
+	if cerr := src_file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(src_path)
+		return ProgramResult[TokenType]{}, "", fmt.Errorf("error writing source file: %w", err)
+	}
+
+	args := compileArgs(exe_path, "-g", viper.GetStringSlice("debug_compile_flags"))
+	result, exe_path, err := compile(ctx, client, nil, append(args, src_path), token, exe_path, logger)
+	if err != nil {
+		os.Remove(src_path)
+	}
+	return result, exe_path, err
+}
+
+// runs gdb as DAP server for the executable until gdb exits or ctx is done
+// dap_in and dap_out carry the Content-Length framed DAP messages
+// gdb starts the program through seccomp_exec, so it runs with landlock, the limits,
+// the input files and the environment of a normal run, gdb itself runs outside of the sandbox
+// the program runs as child of seccomp_exec with OutputDir, so that is not supported
+func RunDebugger(ctx context.Context, exe_path string, dap_in io.Reader, dap_out io.Writer, opts RunOptions, logger *slog.Logger) error {
+	if opts.OutputDir != "" {
+		return errors.New("Ausgabedateien werden im Debugger nicht unterstützt")
+	}
+	env, _, err := sandboxEnv(opts)
+	if err != nil {
+		return err
+	}
+	release, err := acquireProcess(ctx, opts.Client, opts.OnQueuePosition)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, viper.GetDuration("debug_timeout"))
+	defer cancel()
+
+	exe_path, err = filepath.Abs(exe_path)
+	if err != nil {
+		return fmt.Errorf("error getting absoulte path to executable: %w", err)
+	}
+
+	args := []string{
+		"--quiet", "--nx",
+		// nothing from the executable or the network may configure gdb
+		"-iex", "set auto-load off",
+		"-iex", "set debuginfod enabled off",
+	}
+	if runtime.GOOS == "windows" {
+		args = append(args, "-iex", "set startup-with-shell off")
+	} else {
+		seccomp_exec, err := filepath.Abs("seccomp_exec")
+		if err != nil {
+			return fmt.Errorf("error getting absolute path to seccomp_exec: %w", err)
+		}
+		// gdb runs the wrapper with the shell and escapes the program arguments for it
+		args = append(args, "-iex", "set exec-wrapper "+shellQuote(seccomp_exec))
+		// the inferior inherits the environment of gdb, from which seccomp_exec reads the limits
+		env = append(env, "SHELL=/bin/sh")
+	}
+
+	cmd := exec.CommandContext(ctx, viper.GetString("gdb_path"), append(args, "--interpreter=dap")...)
+	// the program must not outlive gdb
+	killProcessGroupOnCancel(cmd)
+	cmd.Dir = filepath.Dir(exe_path)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = dap_out
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	stdin_pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating stdin pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		logger.Error("failed to start gdb", "err", err)
+		return fmt.Errorf("error starting gdb: %w", err)
+	}
+	if opts.OnStart != nil {
+		opts.OnStart()
+	}
+
+	go func() {
+		if _, err := io.Copy(stdin_pipe, dap_in); err != nil {
+			logger.Warn("error copying DAP messages to gdb", "err", err)
+		}
+		stdin_pipe.Close()
+	}()
+
+	err = cmd.Wait()
+	if stderr.Len() > 0 {
+		logger.Debug("gdb stderr", "stderr", stderr.String())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("Die Debug-Sitzung hat die Frist überschritten")
+	}
+	return err
+}
+
+// quotes s for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
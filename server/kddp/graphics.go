@@ -0,0 +1,34 @@
+package kddp
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// the file descriptor of the graphics channel in the program
+// the first of cmd.ExtraFiles always becomes fd 3
+const GraphicsFD = 3
+
+// creates the graphics channel and passes its write end to cmd
+// the write end has to be closed once cmd was started
+func graphicsPipe(cmd *exec.Cmd) (r, w *os.File, err error) {
+	r, w, err = os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating graphics pipe: %w", err)
+	}
+	cmd.ExtraFiles = []*os.File{w}
+	return r, w, nil
+}
+
+// copies the graphics channel to w until the program exited
+// if w fails the rest is discarded so that the program does not block
+func copyGraphics(r *os.File, w io.Writer, logger *slog.Logger) {
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		logger.Warn("error copying graphics output", "err", err)
+		io.Copy(io.Discard, r)
+	}
+}
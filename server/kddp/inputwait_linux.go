@@ -0,0 +1,56 @@
+//go:build linux
+
+package kddp
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// the number of the read syscall, other architectures are not supported
+var readSyscall = map[string]int{"amd64": 0, "arm64": 63}
+
+// reports whether the process is currently blocked in read(0, ...)
+// see proc(5) for the format of /proc/<pid>/syscall
+func readsStdin(pid int) (bool, error) {
+	read_nr, ok := readSyscall[runtime.GOARCH]
+	if !ok {
+		return false, fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/syscall", pid))
+	if err != nil {
+		return false, err
+	}
+	return syscallReadsStdin(string(content), read_nr), nil
+}
+
+// the pid of the first child of the process, 0 if it has none (yet)
+func childPid(pid int) (int, error) {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/children", pid, pid))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(fields[0])
+}
+
+// reports whether the content of /proc/<pid>/syscall is a read on fd 0
+// it is "<nr> <arg1> ..." while in a syscall, "running" or "-1 <sp> <pc>" otherwise
+func syscallReadsStdin(content string, read_nr int) bool {
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		return false
+	}
+	nr, err := strconv.Atoi(fields[0])
+	if err != nil || nr != read_nr {
+		return false
+	}
+	fd, err := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+	return err == nil && fd == 0
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package kddp
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyscallReadsStdin(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"running", "running\n", false},
+		{"not in a syscall", "-1 0x7ffd2b3c8e58 0x7f3a1c2d4e5f\n", false},
+		{"read on stdin", "0 0x0 0x55d4c2a0 0x400 0x0 0x0 0x0 0x7ffd2b3c8e58 0x7f3a1c2d4e5f\n", true},
+		{"read on another fd", "0 0x3 0x55d4c2a0 0x400 0x0 0x0 0x0 0x7ffd2b3c8e58 0x7f3a1c2d4e5f\n", false},
+		{"other syscall on stdin", "1 0x0 0x55d4c2a0 0x400 0x0 0x0 0x0 0x7ffd2b3c8e58 0x7f3a1c2d4e5f\n", false},
+		{"read on arm64", "63 0x0 0x55d4c2a0 0x400 0x0 0x0 0x0 0x7ffd2b3c8e58 0x7f3a1c2d4e5f\n", false},
+		{"empty", "", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, syscallReadsStdin(c.content, 0), c.name)
+	}
+	assert.True(t, syscallReadsStdin("63 0x0 0x1 0x1", 63))
+}
+
+func TestChildPid(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := exec.Command("sh", "-c", "sleep 10 & wait")
+	assert.NoError(cmd.Start())
+	defer cmd.Process.Kill()
+
+	var child int
+	assert.Eventually(func() bool {
+		pid, err := childPid(cmd.Process.Pid)
+		child = pid
+		return err == nil && pid != 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotEqual(cmd.Process.Pid, child)
+
+	// a process without children
+	pid, err := childPid(child)
+	assert.NoError(err)
+	assert.Equal(0, pid)
+
+	syscall.Kill(child, syscall.SIGKILL)
+	cmd.Wait()
+	_, err = childPid(cmd.Process.Pid)
+	assert.Error(err)
+}
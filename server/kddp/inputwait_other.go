@@ -0,0 +1,15 @@
+//go:build !linux
+
+package kddp
+
+import "errors"
+
+// the pid of the first child of the process, unsupported on this platform
+func childPid(pid int) (int, error) {
+	return 0, errors.New("input wait detection is only supported on linux")
+}
+
+// reports whether the process is currently blocked in read(0, ...), unsupported on this platform
+func readsStdin(pid int) (bool, error) {
+	return false, errors.New("input wait detection is only supported on linux")
+}
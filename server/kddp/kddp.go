@@ -15,11 +15,12 @@ import (
 	"runtime"
 	"strings"
 	"sync/atomic"
+	"time"
 
+	procqueue "github.com/DDP-Projekt/Spielplatz/server/proc_queue"
 	"github.com/gorilla/websocket"
 	"github.com/spf13/viper"
 	"golang.org/x/exp/constraints"
-	"golang.org/x/sync/semaphore"
 )
 
 func fatal(msg string, args ...any) {
@@ -36,16 +37,48 @@ func init() {
 	}
 }
 
-var proc_sem *semaphore.Weighted
+var proc_sem *procqueue.Queue
 
-func InitializeSemaphore(weight int64) error {
-	if weight < 1 {
-		return errors.New("weight must be at least 1")
+var (
+	// returned (joined with the cause) if no process slot could be acquired
+	ErrServerBusy = errors.New("Der Server ist momentan ausgelastet, versuchen sie es später erneut")
+	// returned if the client already has too many queued processes
+	ErrTooManyProcesses = errors.New("Sie haben zu viele Programme gleichzeitig gestartet, versuchen sie es später erneut")
+)
+
+// initializes the queue that limits and schedules compilations and runs
+func InitializeProcessQueue(cfg procqueue.Config) error {
+	q, err := procqueue.New(cfg)
+	if err != nil {
+		return err
 	}
-	proc_sem = semaphore.NewWeighted(weight)
+	proc_sem = q
 	return nil
 }
 
+// reports whether new jobs are currently rejected
+func QueueFull() bool {
+	return proc_sem != nil && proc_sem.Full()
+}
+
+// waits for a free process slot for at most process_aquire_timeout
+// the returned function releases the slot
+func acquireProcess(ctx context.Context, client procqueue.Client, on_position func(int)) (func(), error) {
+	if proc_sem == nil {
+		return func() {}, nil
+	}
+	sem_ctx, sem_cancel := context.WithTimeout(ctx, viper.GetDuration("process_aquire_timeout"))
+	defer sem_cancel()
+	release, err := proc_sem.Acquire(sem_ctx, client, on_position)
+	if errors.Is(err, procqueue.ErrClientLimit) {
+		return nil, errors.Join(ErrTooManyProcesses, err)
+	}
+	if err != nil {
+		return nil, errors.Join(ErrServerBusy, err)
+	}
+	return release, nil
+}
+
 // constraint that satisfies `json:"token,string"`
 type tokenType interface {
 	string | constraints.Float | constraints.Integer | bool
@@ -64,13 +97,34 @@ type ProgramResult[TokenType tokenType] struct {
 // compiles a DDP program and returns the result of the compilation,
 // the path to the executable,
 // and an error if one occurred
-func CompileDDPProgram[TokenType tokenType](src io.Reader, token TokenType, exe_path string, logger *slog.Logger) (ProgramResult[TokenType], string, error) {
-	var cmd *exec.Cmd
+// the compilation waits in the process queue until ctx is done
+func CompileDDPProgram[TokenType tokenType](ctx context.Context, client procqueue.Client, src io.Reader, token TokenType, exe_path string, logger *slog.Logger) (ProgramResult[TokenType], string, error) {
+	return compile(ctx, client, src, compileArgs(exe_path, "", nil), token, exe_path, logger)
+}
+
+// the kddp arguments to compile a program to exe_path
+// gcc_flags are appended to the flags used to link the program
+func compileArgs(exe_path, gcc_flags string, extra_args []string) []string {
+	args := []string{"kompiliere", "-o", exe_path}
 	if runtime.GOOS == "windows" {
-		cmd = exec.Command("kddp", "kompiliere", "-o", exe_path, "--main", "unsec_main.o")
+		args = append(args, "--main", "unsec_main.o")
+		if gcc_flags != "" {
+			args = append(args, "--gcc_optionen="+gcc_flags)
+		}
 	} else {
-		cmd = exec.Command("kddp", "kompiliere", "-o", exe_path, "--main", "seccomp_main.o", "--gcc_optionen=-lseccomp -static -no-pie")
+		args = append(args, "--main", "seccomp_main.o", strings.TrimSpace("--gcc_optionen=-lseccomp -static -no-pie "+gcc_flags))
 	}
+	return append(args, extra_args...)
+}
+
+func compile[TokenType tokenType](ctx context.Context, client procqueue.Client, src io.Reader, args []string, token TokenType, exe_path string, logger *slog.Logger) (ProgramResult[TokenType], string, error) {
+	release, err := acquireProcess(ctx, client, nil)
+	if err != nil {
+		return ProgramResult[TokenType]{}, "", err
+	}
+	defer release()
+
+	cmd := exec.Command("kddp", args...)
 
 	cmd.Stdin = src
 	stderr := &strings.Builder{}
@@ -99,58 +153,252 @@ func CompileDDPProgram[TokenType tokenType](src io.Reader, token TokenType, exe_
 	}, exe_path, nil
 }
 
-// runs an executable and returns the result of the execution
-func RunExecutable(exe_path string, stdin io.Reader, stdout, stderr io.Writer, args []string, logger *slog.Logger) (int, error) {
-	if proc_sem != nil {
-		sem_ctx, sem_cancel := context.WithTimeout(context.Background(), viper.GetDuration("process_aquire_timeout"))
-		defer sem_cancel()
-		if err := proc_sem.Acquire(sem_ctx, 1); err != nil {
-			return -1, errors.Join(errors.New("Der Server ist momentan ausgelastet, versuchen sie es später erneut"), err)
+// polls whether the process is blocked reading stdin and calls on_wait when that changes
+// the process has to be blocked for two polls in a row, so that short reads of buffered input are not reported
+func watchInputWait(reads_stdin func() (bool, error), interval time.Duration, exited <-chan struct{}, on_wait func(bool), logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	waiting, blocked_polls := false, 0
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+		}
+		blocked, err := reads_stdin()
+		if err != nil {
+			logger.Debug("stopped watching for input waits", "err", err)
+			return
+		}
+		if blocked {
+			blocked_polls++
+		} else {
+			blocked_polls = 0
+		}
+		if now_waiting := blocked_polls >= 2; now_waiting != waiting {
+			waiting = now_waiting
+			on_wait(waiting)
 		}
-		defer proc_sem.Release(1)
 	}
+}
+
+// options for a single run of an executable
+type RunOptions struct {
+	Args []string
+	// the client that started the run, used for scheduling
+	Client procqueue.Client
+	// called with the 1-based queue position while the run waits for a process slot
+	OnQueuePosition func(int)
+	// deadline for the run, capped at and defaulting to run_timeout
+	Timeout time.Duration
+	// called right after the process was started
+	OnStart func()
+	// if set, the run is sampled by `perf record` which writes its data to this path
+	ProfileOutput string
+	// called with true when the program blocks waiting for input and with false when it continues
+	OnInputWait func(waiting bool)
+	// if set, the program can write drawing commands to GraphicsFD which are copied to Graphics
+	// Graphics is closed after the run if it is an io.Closer, not supported on windows
+	Graphics io.Writer
+	// directory with the input files, the program can only read the files in it
+	// enforced by seccomp_exec with landlock, the run fails if that is not possible
+	InputDir string
+	// writable working directory of the program, replaces InputDir (the caller copies the input files into it)
+	// the run is ended with ErrOutputQuota once the files in it exceed OutputMaxBytes or OutputMaxFiles,
+	// seccomp_exec enforces that with a tmpfs of that size mounted over the directory
+	OutputDir      string
+	OutputMaxBytes int64
+	OutputMaxFiles int
+	// interactive runs are limited by run_session_limit instead of run_timeout
+	// and are ended after run_idle_timeout without input or output
+	Interactive bool
+	// the environment of the program as NAME=value, the caller validates it
+	// if a locale is set the program may read run_locale_dir
+	Env []string
+}
+
+// resource usage and exit code of a finished run
+type RunResult struct {
+	ExitCode int
+	// the signal that killed the process (e.g. SIGKILL), "" if it exited
+	Signal string
+	// time between start and exit of the process, without waiting in the queue
+	WallTime time.Duration
+	// user and system cpu time
+	CPUTime time.Duration
+	// peak resident set size in bytes, 0 if unknown
+	MaxRSS int64
+}
+
+// returns err of RunExecutable unless the program just exited with a non-zero exit code,
+// which is a normal result for callers that check the exit code themselves
+func IgnoreExitCode(result RunResult, err error) error {
+	var exit_err *exec.ExitError
+	if errors.As(err, &exit_err) && result.ExitCode >= 0 {
+		return nil
+	}
+	return err
+}
+
+// runs an executable and returns the result of the execution
+// the run is aborted when ctx is done
+func RunExecutable(ctx context.Context, exe_path string, stdin io.Reader, stdout, stderr io.Writer, opts RunOptions, logger *slog.Logger) (RunResult, error) {
+	failed := RunResult{ExitCode: -1}
+	release, err := acquireProcess(ctx, opts.Client, opts.OnQueuePosition)
+	if err != nil {
+		return failed, err
+	}
+	defer release()
+	args := opts.Args
 
-	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("run_timeout"))
-	defer cancel()
+	timeout, timeout_err := viper.GetDuration("run_timeout"), ErrTimeout
+	if opts.Interactive {
+		timeout, timeout_err = viper.GetDuration("run_session_limit"), ErrSessionLimit
+	}
+	if opts.Timeout > 0 {
+		timeout = min(opts.Timeout, timeout)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	ctx, cancel_timeout := context.WithTimeoutCause(ctx, timeout, timeout_err)
+	defer cancel_timeout()
 
-	var err error
 	exe_path, err = filepath.Abs(exe_path)
 	if err != nil {
 		logger.Error("failed to get absolute path to executable", "err", err)
-		return -1, fmt.Errorf("error getting absoulte path to executable: %w", err)
+		return failed, fmt.Errorf("error getting absoulte path to executable: %w", err)
 	}
 	logger = logger.With("exe_path", exe_path)
 
 	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
+	switch {
+	case opts.ProfileOutput != "" && runtime.GOOS == "windows":
+		return failed, errors.New("profiling is not supported on windows")
+	case runtime.GOOS == "windows":
 		cmd = exec.CommandContext(ctx, exe_path, args...)
-	} else {
+	case opts.ProfileOutput != "":
+		// perf starts the sandboxed program and samples it from the outside
+		args = append([]string{
+			"record", "--quiet",
+			"--freq", fmt.Sprint(viper.GetInt("profile_frequency")),
+			"--call-graph", viper.GetString("profile_call_graph"),
+			"--output", opts.ProfileOutput,
+			// dwarf call graphs copy the stack with every sample, perf stops recording at this size
+			"--max-size", fmt.Sprintf("%dB", viper.GetInt64("profile_max_data_bytes")),
+			"--", "./seccomp_exec", exe_path,
+		}, args...)
+		cmd = exec.CommandContext(ctx, viper.GetString("perf_path"), args...)
+		killProcessGroupOnCancel(cmd)
+	default:
 		args = append([]string{exe_path}, args...)
 		cmd = exec.CommandContext(ctx, "./seccomp_exec", args...)
 	}
 
+	// seccomp_exec (also when started by perf) applies the limits before executing the program
+	env, files_dir, err := sandboxEnv(opts)
+	if err != nil {
+		return failed, err
+	}
+	if files_dir != "" {
+		cmd.Dir = files_dir
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cpu_limit := viper.GetDuration("run_cpu_limit")
+
+	idle_timeout := viper.GetDuration("run_idle_timeout")
+	watch_idle := opts.Interactive && idle_timeout > 0
+	var act activity
+	if watch_idle {
+		stdin, stdout, stderr = act.reader(stdin), act.writer(stdout), act.writer(stderr)
+	}
+
 	cmd.Stderr = stderr
 	cmd.Stdout = stdout
+
+	var graphics_r, graphics_w *os.File
+	if opts.Graphics != nil && runtime.GOOS != "windows" {
+		if graphics_r, graphics_w, err = graphicsPipe(cmd); err != nil {
+			logger.Error("failed to create graphics pipe", "err", err)
+			return failed, err
+		}
+		defer graphics_w.Close()
+	}
+
 	stdin_pipe, err := cmd.StdinPipe()
 	if err != nil {
 		logger.Error("failed to create stdin pipe", "err", err)
-		return -1, fmt.Errorf("error creating stdin pipe: %w", err)
+		return failed, fmt.Errorf("error creating stdin pipe: %w", err)
 	}
 
+	start := time.Now()
 	if err := cmd.Start(); err != nil {
+		if graphics_r != nil {
+			graphics_r.Close()
+		}
 		logger.Error("failed to start executable", "err", err)
-		return -1, fmt.Errorf("error starting executable: %w", err)
+		return failed, fmt.Errorf("error starting executable: %w", err)
+	}
+	act.touch()
+
+	graphics_done := make(chan struct{})
+	if graphics_r != nil {
+		// only the program may keep the channel open
+		graphics_w.Close()
+		graphics := opts.Graphics
+		if watch_idle {
+			graphics = act.writer(graphics)
+		}
+		go func() {
+			copyGraphics(graphics_r, graphics, logger)
+			close(graphics_done)
+		}()
+	} else {
+		close(graphics_done)
+	}
+	if opts.OnStart != nil {
+		opts.OnStart()
 	}
 
 	done := make(chan error)
 	is_done := atomic.Bool{}
+	exited := make(chan struct{})
 
+	var wall_time time.Duration
 	go func() {
 		err := cmd.Wait()
+		wall_time = time.Since(start)
 		is_done.Store(true)
+		close(exited)
 		done <- err
 	}()
 
+	// with perf the process is not the program itself
+	if opts.OnInputWait != nil && opts.ProfileOutput == "" {
+		pid := cmd.Process.Pid
+		reads_stdin := func() (bool, error) { return readsStdin(pid) }
+		// with an output directory seccomp_exec runs the program as its child
+		if opts.OutputDir != "" {
+			reads_stdin = func() (bool, error) {
+				child, err := childPid(pid)
+				if err != nil || child == 0 {
+					return false, err
+				}
+				return readsStdin(child)
+			}
+		}
+		go watchInputWait(reads_stdin, viper.GetDuration("input_wait_poll_interval"), exited, opts.OnInputWait, logger)
+	}
+	if watch_idle {
+		go watchIdle(&act, idle_timeout, exited, cancel)
+	}
+	// elsewhere seccomp_exec mounts a size limited tmpfs for the files, which the server can not see during the run
+	if opts.OutputDir != "" && runtime.GOOS == "windows" {
+		go watchOutputDir(opts.OutputDir, opts.OutputMaxBytes, opts.OutputMaxFiles, viper.GetDuration("output_files_poll_interval"), exited, cancel, logger)
+	}
+
 	go func() {
 		isBadReadErr := func(err error) bool {
 			if err == nil {
@@ -165,26 +413,44 @@ func RunExecutable(exe_path string, stdin io.Reader, stdout, stderr io.Writer, a
 
 		if _, err := io.Copy(stdin_pipe, stdin); isBadReadErr(err) {
 			logger.Warn("error copying stdin to process", "err", err)
-			cancel()
+			cancel(context.Canceled)
 		}
 		logger.Info("closing stdin pipe")
 		stdin_pipe.Close()
 	}()
 
 	err = <-done
-	if cerr := ctx.Err(); cerr != nil {
-		switch cerr {
-		case context.DeadlineExceeded:
+	<-graphics_done
+	if closer, ok := opts.Graphics.(io.Closer); ok {
+		closer.Close()
+	}
+	if ctx.Err() != nil {
+		switch cause := context.Cause(ctx); {
+		case errors.Is(cause, context.DeadlineExceeded):
 			logger.Info("deadline exceeded")
-			err = errors.New("Das Programm hat die Frist überschritten")
-		case context.Canceled:
+			err = ErrTimeout
+		case errors.Is(cause, context.Canceled):
 			logger.Info("program cancelled")
-			err = fmt.Errorf("Das Programm wurde abgebrochen: %w", cerr)
+			err = fmt.Errorf("Das Programm wurde abgebrochen: %w", cause)
 		default:
-			err = cerr
+			logger.Info("program stopped", "reason", LimitReason(cause))
+			err = cause
 		}
+	} else if cpu_limit > 0 && exceededCPULimit(cmd.ProcessState, cpu_limit) {
+		logger.Info("cpu time limit exceeded")
+		err = ErrCPULimit
+	} else if opts.OutputDir != "" && (exceededFileSizeLimit(cmd.ProcessState) || exceedsQuota(opts.OutputDir, opts.OutputMaxBytes, opts.OutputMaxFiles, logger)) {
+		// the last files might have been written after the last check
+		logger.Info("output quota exceeded")
+		err = ErrOutputQuota
 	}
-	return cmd.ProcessState.ExitCode(), err
+	return RunResult{
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Signal:   exitSignal(cmd.ProcessState),
+		WallTime: wall_time,
+		CPUTime:  cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime(),
+		MaxRSS:   maxRSS(cmd.ProcessState),
+	}, err
 }
 
 // CompilerResult is the result of a compilation
@@ -212,3 +478,53 @@ func GetKDDPVersion() (VersionResult, error) {
 		Stdout:     stdout.String(),
 	}, err
 }
+
+// the variables from which seccomp_exec reads the limits, the files and the environment of the program
+// on windows there is no seccomp_exec, instead the program has to be started in dir
+func sandboxEnv(opts RunOptions) (env []string, dir string, err error) {
+	cpu_limit := viper.GetDuration("run_cpu_limit")
+	if cpu_limit > 0 && runtime.GOOS != "windows" {
+		env = append(env, cpuLimitEnvVar(cpu_limit))
+	}
+	files_dir, files_env := opts.InputDir, inputDirEnv
+	if opts.OutputDir != "" {
+		files_dir, files_env = opts.OutputDir, outputDirEnv
+		env = append(env,
+			fmt.Sprintf("%s=%d", fileSizeLimitEnv, opts.OutputMaxBytes),
+			fmt.Sprintf("%s=%d", outputMaxBytesEnv, opts.OutputMaxBytes),
+			fmt.Sprintf("%s=%d", outputMaxFilesEnv, opts.OutputMaxFiles),
+		)
+	}
+	if files_dir != "" {
+		files_dir, err = filepath.Abs(files_dir)
+		if err != nil {
+			return nil, "", fmt.Errorf("error getting absolute path to the files: %w", err)
+		}
+		if runtime.GOOS == "windows" {
+			dir = files_dir
+		} else {
+			env = append(env, files_env+"="+files_dir)
+		}
+	}
+	return append(env, programEnv(opts.Env)...), dir, nil
+}
+
+// the variables for seccomp_exec to set up the environment of the program
+func programEnv(vars []string) []string {
+	// there is no seccomp_exec on windows
+	if runtime.GOOS == "windows" {
+		return vars
+	}
+	var env []string
+	sets_locale := false
+	for _, v := range vars {
+		env = append(env, programEnvPrefix+v)
+		if strings.HasPrefix(v, "LANG=") || strings.HasPrefix(v, "LC_") {
+			sets_locale = true
+		}
+	}
+	if sets_locale {
+		env = append(env, localeDirEnv+"="+viper.GetString("run_locale_dir"))
+	}
+	return env
+}
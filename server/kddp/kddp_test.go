@@ -0,0 +1,66 @@
+package kddp
+
+import (
+	"errors"
+	"log/slog"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchInputWait(t *testing.T) {
+	assert := assert.New(t)
+
+	// the results of the polls, the watcher stops at the error
+	polls := []bool{true, false, true, true, true, false, true, true}
+	reads_stdin := func() (bool, error) {
+		if len(polls) == 0 {
+			return false, errors.New("exited")
+		}
+		blocked := polls[0]
+		polls = polls[1:]
+		return blocked, nil
+	}
+	var waits []bool
+	watchInputWait(reads_stdin, time.Millisecond, make(chan struct{}), func(waiting bool) {
+		waits = append(waits, waiting)
+	}, slog.Default())
+
+	// a single blocked poll is not reported
+	assert.Equal([]bool{true, false, true}, waits)
+}
+
+func TestWatchInputWaitStopsWhenExited(t *testing.T) {
+	exited := make(chan struct{})
+	close(exited)
+	watchInputWait(func() (bool, error) {
+		t.Error("polled after the process exited")
+		return false, nil
+	}, time.Hour, exited, func(bool) {}, slog.Default())
+}
+
+func TestSandboxEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("there is no seccomp_exec on windows")
+	}
+	assert := assert.New(t)
+	old := viper.Get("run_cpu_limit")
+	viper.Set("run_cpu_limit", 1500*time.Millisecond)
+	t.Cleanup(func() { viper.Set("run_cpu_limit", old) })
+
+	input_dir := t.TempDir()
+	env, dir, err := sandboxEnv(RunOptions{InputDir: input_dir, Env: []string{"TZ=CET-1"}})
+	assert.NoError(err)
+	assert.Equal("", dir)
+	assert.Equal([]string{cpuLimitEnvVar(1500 * time.Millisecond), inputDirEnv + "=" + input_dir, programEnvPrefix + "TZ=CET-1"}, env)
+
+	env, _, err = sandboxEnv(RunOptions{OutputDir: input_dir, OutputMaxBytes: 10, OutputMaxFiles: 2})
+	assert.NoError(err)
+	assert.Contains(env, outputDirEnv+"="+input_dir)
+	assert.Contains(env, outputMaxBytesEnv+"=10")
+	assert.Contains(env, outputMaxFilesEnv+"=2")
+	assert.NotContains(env, inputDirEnv+"="+input_dir)
+}
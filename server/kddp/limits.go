@@ -0,0 +1,172 @@
+package kddp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// the reasons why a run can be ended by the server
+var (
+	ErrTimeout      = errors.New("Das Programm hat die Frist überschritten")
+	ErrSessionLimit = errors.New("Das Programm hat die maximale Sitzungsdauer überschritten")
+	ErrIdleTimeout  = errors.New("Das Programm war zu lange ohne Ein- oder Ausgabe")
+	ErrCPULimit     = errors.New("Das Programm hat das CPU-Zeitlimit überschritten")
+	ErrOutputQuota  = errors.New("Das Programm hat zu viele oder zu große Dateien geschrieben")
+)
+
+// a short machine readable name for limit errors, "" for all other errors
+func LimitReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrSessionLimit):
+		return "session_limit"
+	case errors.Is(err, ErrIdleTimeout):
+		return "idle_timeout"
+	case errors.Is(err, ErrCPULimit):
+		return "cpu_limit"
+	case errors.Is(err, ErrOutputQuota):
+		return "output_quota"
+	}
+	return ""
+}
+
+const (
+	// the environment variable from which seccomp_exec reads the cpu time limit in seconds
+	cpuLimitEnv = "SPIELPLATZ_CPU_LIMIT"
+	// the environment variable from which seccomp_exec reads the directory of the input files
+	inputDirEnv = "SPIELPLATZ_INPUT_DIR"
+	// like inputDirEnv, but the program may also write files in the directory
+	outputDirEnv = "SPIELPLATZ_OUTPUT_DIR"
+	// the environment variable from which seccomp_exec reads the maximum size of written files
+	fileSizeLimitEnv = "SPIELPLATZ_FILE_SIZE_LIMIT"
+	// the environment variables from which seccomp_exec reads the size of the tmpfs it mounts over the output directory
+	outputMaxBytesEnv = "SPIELPLATZ_OUTPUT_MAX_BYTES"
+	outputMaxFilesEnv = "SPIELPLATZ_OUTPUT_MAX_FILES"
+	// seccomp_exec passes the variables with this prefix (without it) to the program
+	programEnvPrefix = "SPIELPLATZ_ENV_"
+	// the directory with the locales, which the program may read if it sets a locale
+	localeDirEnv = "SPIELPLATZ_LOCALE_DIR"
+)
+
+// RLIMIT_CPU only knows whole seconds, so the limit is rounded up
+func cpuLimitSeconds(limit time.Duration) int64 {
+	return int64((limit + time.Second - 1) / time.Second)
+}
+
+func cpuLimitEnvVar(limit time.Duration) string {
+	return fmt.Sprintf("%s=%d", cpuLimitEnv, cpuLimitSeconds(limit))
+}
+
+// the last time the program read input or wrote output
+type activity struct {
+	last atomic.Int64
+}
+
+func (a *activity) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+func (a *activity) idle() time.Duration {
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+func (a *activity) reader(r io.Reader) io.Reader {
+	return activityReader{r, a}
+}
+
+func (a *activity) writer(w io.Writer) io.Writer {
+	return activityWriter{w, a}
+}
+
+type activityReader struct {
+	r io.Reader
+	a *activity
+}
+
+func (r activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.a.touch()
+	}
+	return n, err
+}
+
+type activityWriter struct {
+	w io.Writer
+	a *activity
+}
+
+func (w activityWriter) Write(p []byte) (int, error) {
+	w.a.touch()
+	return w.w.Write(p)
+}
+
+// cancels the run with ErrIdleTimeout once there was no activity for timeout
+// returns when the process exited
+func watchIdle(a *activity, timeout time.Duration, exited <-chan struct{}, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(max(min(timeout/10, time.Second), time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+			if a.idle() >= timeout {
+				cancel(ErrIdleTimeout)
+				return
+			}
+		}
+	}
+}
+
+// the total size and number of the files in dir
+func dirUsage(dir string) (size int64, files int, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // deleted in the meantime
+		}
+		size += info.Size()
+		files++
+	}
+	return size, files, nil
+}
+
+// reports if the files in dir exceed max_bytes or max_files
+func exceedsQuota(dir string, max_bytes int64, max_files int, logger *slog.Logger) bool {
+	size, files, err := dirUsage(dir)
+	if err != nil {
+		logger.Warn("failed to check output directory", "err", err)
+		return false
+	}
+	return size > max_bytes || files > max_files
+}
+
+// cancels the run with ErrOutputQuota once the files in dir exceed the quota
+// returns when the process exited
+func watchOutputDir(dir string, max_bytes int64, max_files int, interval time.Duration, exited <-chan struct{}, cancel context.CancelCauseFunc, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+			if exceedsQuota(dir, max_bytes, max_files, logger) {
+				cancel(ErrOutputQuota)
+				return
+			}
+		}
+	}
+}
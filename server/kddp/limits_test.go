@@ -0,0 +1,140 @@
+package kddp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitReason(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("timeout", LimitReason(ErrTimeout))
+	assert.Equal("session_limit", LimitReason(ErrSessionLimit))
+	assert.Equal("idle_timeout", LimitReason(ErrIdleTimeout))
+	assert.Equal("cpu_limit", LimitReason(ErrCPULimit))
+	assert.Equal("output_quota", LimitReason(ErrOutputQuota))
+	assert.Equal("cpu_limit", LimitReason(fmt.Errorf("run failed: %w", ErrCPULimit)))
+	assert.Equal("", LimitReason(errors.New("other")))
+	assert.Equal("", LimitReason(nil))
+}
+
+func TestCPULimitSeconds(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(int64(1), cpuLimitSeconds(time.Millisecond))
+	assert.Equal(int64(1), cpuLimitSeconds(time.Second))
+	assert.Equal(int64(2), cpuLimitSeconds(1500*time.Millisecond))
+	assert.Equal("SPIELPLATZ_CPU_LIMIT=3", cpuLimitEnvVar(3*time.Second))
+}
+
+func TestActivity(t *testing.T) {
+	assert := assert.New(t)
+
+	var a activity
+	a.touch()
+	time.Sleep(5 * time.Millisecond)
+	assert.GreaterOrEqual(a.idle(), 5*time.Millisecond)
+
+	// reading and writing count as activity
+	time.Sleep(5 * time.Millisecond)
+	data, err := io.ReadAll(a.reader(strings.NewReader("Eingabe")))
+	assert.NoError(err)
+	assert.Equal("Eingabe", string(data))
+	assert.Less(a.idle(), 5*time.Millisecond)
+
+	time.Sleep(5 * time.Millisecond)
+	var out bytes.Buffer
+	_, err = a.writer(&out).Write([]byte("Ausgabe"))
+	assert.NoError(err)
+	assert.Equal("Ausgabe", out.String())
+	assert.Less(a.idle(), 5*time.Millisecond)
+
+	// reads without data do not
+	time.Sleep(5 * time.Millisecond)
+	a.reader(strings.NewReader("")).Read(make([]byte, 1))
+	assert.GreaterOrEqual(a.idle(), 5*time.Millisecond)
+}
+
+func TestWatchIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	var a activity
+	a.touch()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	start := time.Now()
+	watchIdle(&a, 20*time.Millisecond, make(chan struct{}), cancel)
+	assert.ErrorIs(context.Cause(ctx), ErrIdleTimeout)
+	assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+
+	// activity postpones the timeout
+	a.touch()
+	ctx, cancel = context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	done := make(chan struct{})
+	go func() {
+		watchIdle(&a, 50*time.Millisecond, make(chan struct{}), cancel)
+		close(done)
+	}()
+	for range 5 {
+		time.Sleep(20 * time.Millisecond)
+		a.touch()
+	}
+	assert.NoError(context.Cause(ctx))
+	<-done
+	assert.ErrorIs(context.Cause(ctx), ErrIdleTimeout)
+
+	// the watcher stops when the process exited
+	exited := make(chan struct{})
+	close(exited)
+	ctx, cancel = context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	watchIdle(&a, time.Hour, exited, cancel)
+	assert.NoError(context.Cause(ctx))
+}
+
+func TestDirUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	size, files, err := dirUsage(dir)
+	assert.NoError(err)
+	assert.Equal(int64(0), size)
+	assert.Equal(0, files)
+
+	assert.NoError(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hallo"), 0o644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "b.txt"), make([]byte, 100), 0o444))
+	size, files, err = dirUsage(dir)
+	assert.NoError(err)
+	assert.Equal(int64(105), size)
+	assert.Equal(2, files)
+
+	_, _, err = dirUsage(filepath.Join(dir, "fehlt"))
+	assert.Error(err)
+}
+
+func TestExceedsQuota(t *testing.T) {
+	assert := assert.New(t)
+	logger := slog.Default()
+
+	dir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 10), 0o644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "b.txt"), make([]byte, 10), 0o644))
+
+	assert.False(exceedsQuota(dir, 20, 2, logger))
+	assert.True(exceedsQuota(dir, 19, 2, logger))
+	assert.True(exceedsQuota(dir, 20, 1, logger))
+	// a directory that can not be read does not end the run
+	assert.False(exceedsQuota(filepath.Join(dir, "fehlt"), 0, 0, logger))
+}
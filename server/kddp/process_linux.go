@@ -0,0 +1,68 @@
+//go:build linux
+
+package kddp
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// runs cmd in its own process group that is killed as a whole when the context is done
+// used when cmd is a wrapper (like perf) that would otherwise leave the program running
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// reports if the process was killed because it exceeded its RLIMIT_CPU
+// the kernel sends SIGXCPU at the soft limit and SIGKILL a second later at the hard limit
+func exceededCPULimit(state *os.ProcessState, limit time.Duration) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		return state.UserTime()+state.SystemTime() >= time.Duration(cpuLimitSeconds(limit))*time.Second
+	}
+	return false
+}
+
+// reports if the process was killed because it wrote a file larger than its RLIMIT_FSIZE
+func exceededFileSizeLimit(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXFSZ
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGSYS:  "SIGSYS",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+}
+
+// the name of the signal that killed the process, "" if it exited normally
+func exitSignal(state *os.ProcessState) string {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	if name, ok := signalNames[status.Signal()]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(status.Signal()))
+}
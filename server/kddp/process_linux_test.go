@@ -0,0 +1,32 @@
+//go:build linux
+
+package kddp
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExceededCPULimit(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		limit  time.Duration
+		want   bool
+	}{
+		{"exited", "exit 0", time.Second, false},
+		{"exit code", "exit 3", time.Second, false},
+		{"soft limit", "kill -XCPU $$", time.Second, true},
+		// a SIGKILL before the limit was used up came from somewhere else
+		{"killed early", "kill -KILL $$", time.Minute, false},
+		{"other signal", "kill -SEGV $$", time.Second, false},
+	}
+	for _, c := range cases {
+		cmd := exec.Command("sh", "-c", c.script)
+		cmd.Run()
+		assert.Equal(t, c.want, exceededCPULimit(cmd.ProcessState, c.limit), c.name)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package kddp
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// process groups are not used on this platform
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
+
+// the cpu time limit is only enforced on linux
+func exceededCPULimit(state *os.ProcessState, limit time.Duration) bool {
+	return false
+}
+
+// the file size limit is only enforced on linux
+func exceededFileSizeLimit(state *os.ProcessState) bool {
+	return false
+}
+
+// processes are not killed by signals on this platform
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
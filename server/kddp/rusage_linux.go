@@ -0,0 +1,16 @@
+//go:build linux
+
+package kddp
+
+import (
+	"os"
+	"syscall"
+)
+
+// peak resident set size of the finished process in bytes
+func maxRSS(state *os.ProcessState) int64 {
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return rusage.Maxrss * 1024 // Maxrss is in KiB on linux
+	}
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package kddp
+
+import "os"
+
+// peak resident set size of the finished process in bytes, unknown on this platform
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
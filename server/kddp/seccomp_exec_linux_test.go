@@ -0,0 +1,73 @@
+package kddp
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tries to change the input file and writes an output file
+// the exit code has a bit set for every check that failed
+const filesTestProgram = `
+#include <fcntl.h>
+#include <unistd.h>
+
+int main(void) {
+    int status = 0;
+    if (open("eingabe.txt", O_WRONLY | O_TRUNC) >= 0) status |= 1;
+    if (open("eingabe.txt", O_RDONLY | O_TRUNC) >= 0) status |= 2;
+    if (unlink("eingabe.txt") == 0) status |= 4;
+    int fd = open("ausgabe.txt", O_WRONLY | O_CREAT | O_TRUNC, 0644);
+    if (fd < 0 || write(fd, "ok", 2) != 2) status |= 8;
+    return status;
+}
+`
+
+// compiles src with gcc, skips the test if that is not possible (e.g. without libseccomp)
+func compileC(t *testing.T, out string, args ...string) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc is not installed")
+	}
+	if output, err := exec.Command("gcc", append([]string{"-O2", "-o", out}, args...)...).CombinedOutput(); err != nil {
+		t.Skipf("could not compile %s: %s", out, output)
+	}
+}
+
+func TestSeccompExecOutputDir(t *testing.T) {
+	assert := assert.New(t)
+	bin := t.TempDir()
+	seccomp_exec, program := filepath.Join(bin, "seccomp_exec"), filepath.Join(bin, "program")
+	compileC(t, seccomp_exec, "../../seccomp_main/seccomp_exec.c", "-lseccomp")
+	src := filepath.Join(bin, "program.c")
+	require.NoError(t, os.WriteFile(src, []byte(filesTestProgram), 0o644))
+	// static, because landlock only allows the program to read its working directory
+	compileC(t, program, "-static", src)
+
+	// like createScratchDir
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "eingabe.txt"), []byte("1 2 3"), 0o444))
+	cmd := exec.Command(seccomp_exec, program)
+	cmd.Env = []string{
+		outputDirEnv + "=" + dir,
+		fmt.Sprintf("%s=%d", outputMaxBytesEnv, 1024),
+		fmt.Sprintf("%s=%d", outputMaxFilesEnv, 2),
+	}
+	output, err := cmd.CombinedOutput()
+	if strings.Contains(string(output), "nicht unterstützt") {
+		t.Skipf("no landlock or user namespaces: %s", output)
+	}
+	assert.NoError(err, string(output))
+
+	content, err := os.ReadFile(filepath.Join(dir, "eingabe.txt"))
+	assert.NoError(err)
+	assert.Equal("1 2 3", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "ausgabe.txt"))
+	assert.NoError(err)
+	assert.Equal("ok", string(content))
+}
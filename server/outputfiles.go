@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	wsrw "github.com/DDP-Projekt/Spielplatz/server/websocket_rw"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+var (
+	// the scratch directories with downloadable output files by their download id
+	outputDirs      = map[string]string{}
+	outputDirsMutex sync.Mutex
+)
+
+// creates the scratch directory of a run and copies the input files into it
+// returns the directory and the total size and number of the input files
+func createScratchDir(input_dir string) (string, int64, int, error) {
+	dir, err := os.MkdirTemp(executables.Dir(), "Spielplatz_run_*")
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("error creating scratch directory: %w", err)
+	}
+	if input_dir == "" {
+		return dir, 0, 0, nil
+	}
+	entries, err := os.ReadDir(input_dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", 0, 0, fmt.Errorf("error reading input files: %w", err)
+	}
+	var size int64
+	for _, entry := range entries {
+		n, err := copyInputFile(filepath.Join(input_dir, entry.Name()), filepath.Join(dir, entry.Name()))
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", 0, 0, err
+		}
+		size += n
+	}
+	return dir, size, len(entries), nil
+}
+
+// the copy stays read-only, so the input files are never part of the output
+func copyInputFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o444)
+	if err != nil {
+		return 0, fmt.Errorf("error creating input file: %w", err)
+	}
+	n, err := io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error copying input file: %w", err)
+	}
+	return n, nil
+}
+
+// lists the files the program wrote into dir and makes them downloadable for output_files_ttl
+// dir is removed right away if there are none
+func publishOutputFiles(dir string, logger *slog.Logger) []wsrw.OutputFile {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("failed to list output files", "err", err)
+	}
+	var files []wsrw.OutputFile
+	for _, entry := range entries {
+		info, err := entry.Info()
+		// input files are read-only
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o200 == 0 {
+			continue
+		}
+		files = append(files, wsrw.OutputFile{Name: entry.Name(), Size: info.Size()})
+	}
+	if len(files) == 0 {
+		executables.RemoveScratch(dir)
+		return nil
+	}
+
+	id, err := generateRunToken()
+	if err != nil {
+		logger.Error("failed to generate download id", "err", err)
+		executables.RemoveScratch(dir)
+		return nil
+	}
+	for i := range files {
+		files[i].URL = "/spielplatz/run/files/" + id + "/" + url.PathEscape(files[i].Name)
+	}
+
+	outputDirsMutex.Lock()
+	outputDirs[id] = dir
+	outputDirsMutex.Unlock()
+	time.AfterFunc(viper.GetDuration("output_files_ttl"), func() {
+		outputDirsMutex.Lock()
+		delete(outputDirs, id)
+		outputDirsMutex.Unlock()
+		executables.RemoveScratch(dir)
+	})
+	logger.Info("output files are downloadable", "files", len(files))
+	return files
+}
+
+// serves the /run/files/:id/:name endpoint
+func serve_output_file(c *gin.Context) {
+	outputDirsMutex.Lock()
+	dir, ok := outputDirs[c.Param("id")]
+	outputDirsMutex.Unlock()
+	name := c.Param("name")
+	if !ok || name != filepath.Base(name) || name == "." || name == ".." {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Die Datei existiert nicht oder ist abgelaufen"})
+		return
+	}
+	path := filepath.Join(dir, name)
+	if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Die Datei existiert nicht oder ist abgelaufen"})
+		return
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.FileAttachment(path, name)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishOutputFiles(t *testing.T) {
+	assert := assert.New(t)
+	setConfig(t, "output_files_ttl", time.Hour)
+
+	// only input files, the directory is removed
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "eingabe.txt"), []byte("1 2 3"), 0o444))
+	assert.Nil(publishOutputFiles(dir, slog.Default()))
+	assert.NoDirExists(dir)
+
+	dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "eingabe.txt"), []byte("1 2 3"), 0o444))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bericht 1.csv"), []byte("a;b"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "ordner"), 0o755))
+	files := publishOutputFiles(dir, slog.Default())
+	require.Len(t, files, 1)
+	assert.Equal("bericht 1.csv", files[0].Name)
+	assert.Equal(int64(3), files[0].Size)
+	assert.True(strings.HasSuffix(files[0].URL, "/bericht%201.csv"), files[0].URL)
+
+	id := strings.Split(strings.TrimPrefix(files[0].URL, "/spielplatz/run/files/"), "/")[0]
+	outputDirsMutex.Lock()
+	assert.Equal(dir, outputDirs[id])
+	outputDirsMutex.Unlock()
+	t.Cleanup(func() {
+		outputDirsMutex.Lock()
+		delete(outputDirs, id)
+		outputDirsMutex.Unlock()
+	})
+
+	r := testRouter()
+	r.GET("/spielplatz/run/files/:id/:name", serve_output_file)
+	w := request(r, http.MethodGet, files[0].URL, nil, nil)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("a;b", w.Body.String())
+	assert.Equal(http.StatusNotFound, request(r, http.MethodGet, "/spielplatz/run/files/"+id+"/ordner", nil, nil).Code)
+	assert.Equal(http.StatusNotFound, request(r, http.MethodGet, "/spielplatz/run/files/unbekannt/bericht%201.csv", nil, nil).Code)
+}
@@ -0,0 +1,340 @@
+/*
+package procqueue limits the number of concurrently running processes
+and queues further jobs, serving the waiting clients round-robin
+*/
+package procqueue
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// returned by Acquire if the queue already holds the maximum number of jobs
+	ErrQueueFull = errors.New("queue is full")
+	// returned by Acquire if the client already has the maximum number of queued jobs
+	ErrClientLimit = errors.New("client has too many queued jobs")
+)
+
+// a priority class with its own reserved process slots
+type Class struct {
+	Name string
+	// slots that only jobs of this class may use
+	Reserved int
+	// overrides Config.MaxPerClient for clients of this class if > 0
+	MaxPerClient int
+}
+
+type Config struct {
+	// number of processes that may run concurrently
+	Slots int
+	// maximum number of jobs waiting for a slot
+	MaxQueued int
+	// maximum number of processes a single client may run concurrently, 0 means no limit
+	MaxPerClient int
+	// maximum number of jobs a single client may have queued, 0 means no limit
+	MaxQueuedPerClient int
+	Classes            []Class
+}
+
+// identifies the origin of a job
+type Client struct {
+	ID string
+	// name of the priority class, "" for the default class
+	Class string
+}
+
+type waiter struct {
+	ready    chan struct{}
+	position chan int // always holds only the latest position
+}
+
+type classState struct {
+	Class
+	running int
+}
+
+type clientQueue struct {
+	key       string
+	class     *classState
+	running   int
+	waiting   *list.List    // list of *waiter
+	ring_elem *list.Element // element in Queue.ring while jobs are waiting
+}
+
+// a bounded queue in front of a fixed number of process slots
+// waiting clients are served round-robin, every client
+// is served in FIFO order
+type Queue struct {
+	mu      sync.Mutex
+	cfg     Config
+	shared  int // slots that are not reserved by any class
+	running int
+	waiting int
+	classes map[string]*classState
+	clients map[string]*clientQueue
+	ring    *list.List // list of *clientQueue with waiting jobs, the front is served next
+}
+
+func New(cfg Config) (*Queue, error) {
+	if cfg.Slots < 1 {
+		return nil, errors.New("slots must be at least 1")
+	}
+	if cfg.MaxQueued < 0 || cfg.MaxPerClient < 0 || cfg.MaxQueuedPerClient < 0 {
+		return nil, errors.New("limits must not be negative")
+	}
+
+	q := &Queue{
+		cfg:     cfg,
+		shared:  cfg.Slots,
+		classes: map[string]*classState{"": {}},
+		clients: make(map[string]*clientQueue),
+		ring:    list.New(),
+	}
+	for _, class := range cfg.Classes {
+		if class.Name == "" {
+			return nil, errors.New("priority classes must have a name")
+		}
+		if _, ok := q.classes[class.Name]; ok {
+			return nil, fmt.Errorf("duplicate priority class %q", class.Name)
+		}
+		if class.Reserved < 0 || class.MaxPerClient < 0 {
+			return nil, fmt.Errorf("limits of priority class %q must not be negative", class.Name)
+		}
+		q.classes[class.Name] = &classState{Class: class}
+		q.shared -= class.Reserved
+	}
+	if q.shared < 0 {
+		return nil, errors.New("priority classes reserve more slots than available")
+	}
+	return q, nil
+}
+
+// blocks until the client may start a process, ctx is done or the queue is full
+// on_position (if not nil) is called with the 1-based estimated queue position
+// every time it changes while waiting
+// the returned release function must be called exactly once after the job finished
+func (q *Queue) Acquire(ctx context.Context, client Client, on_position func(int)) (func(), error) {
+	q.mu.Lock()
+	cq, err := q.getClient(client)
+	if err != nil {
+		q.mu.Unlock()
+		return nil, err
+	}
+	if cq.waiting.Len() == 0 && q.canStart(cq) {
+		q.start(cq)
+		q.mu.Unlock()
+		return q.releaseFunc(cq), nil
+	}
+	if q.waiting >= q.cfg.MaxQueued {
+		q.cleanup(cq)
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	if q.cfg.MaxQueuedPerClient > 0 && cq.waiting.Len() >= q.cfg.MaxQueuedPerClient {
+		q.mu.Unlock()
+		return nil, ErrClientLimit
+	}
+
+	w := &waiter{
+		ready:    make(chan struct{}),
+		position: make(chan int, 1),
+	}
+	elem := cq.waiting.PushBack(w)
+	q.waiting++
+	if cq.ring_elem == nil {
+		cq.ring_elem = q.ring.PushBack(cq)
+	}
+	q.notifyPositions()
+	q.mu.Unlock()
+
+	for {
+		select {
+		case <-w.ready:
+			return q.releaseFunc(cq), nil
+		case pos := <-w.position:
+			if on_position != nil {
+				on_position(pos)
+			}
+		case <-ctx.Done():
+			q.mu.Lock()
+			select {
+			case <-w.ready:
+				// the slot was granted concurrently, give it back
+				q.mu.Unlock()
+				q.release(cq)
+			default:
+				cq.waiting.Remove(elem)
+				q.waiting--
+				if cq.waiting.Len() == 0 {
+					q.ring.Remove(cq.ring_elem)
+					cq.ring_elem = nil
+				}
+				q.cleanup(cq)
+				q.notifyPositions()
+				q.mu.Unlock()
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// q.mu must be held
+func (q *Queue) getClient(client Client) (*clientQueue, error) {
+	class, ok := q.classes[client.Class]
+	if !ok {
+		return nil, fmt.Errorf("unknown priority class %q", client.Class)
+	}
+	key := client.Class + "/" + client.ID
+	cq, ok := q.clients[key]
+	if !ok {
+		cq = &clientQueue{
+			key:     key,
+			class:   class,
+			waiting: list.New(),
+		}
+		q.clients[key] = cq
+	}
+	return cq, nil
+}
+
+// forgets idle clients
+// q.mu must be held
+func (q *Queue) cleanup(cq *clientQueue) {
+	if cq.running == 0 && cq.waiting.Len() == 0 {
+		delete(q.clients, cq.key)
+	}
+}
+
+// q.mu must be held
+func (q *Queue) canStart(cq *clientQueue) bool {
+	max_per_client := q.cfg.MaxPerClient
+	if cq.class.MaxPerClient > 0 {
+		max_per_client = cq.class.MaxPerClient
+	}
+	if max_per_client > 0 && cq.running >= max_per_client {
+		return false
+	}
+	if q.running >= q.cfg.Slots {
+		return false
+	}
+	return cq.class.running < cq.class.Reserved || q.sharedUsed() < q.shared
+}
+
+// number of running processes that do not use reserved slots
+// q.mu must be held
+func (q *Queue) sharedUsed() int {
+	used := 0
+	for _, class := range q.classes {
+		used += max(class.running-class.Reserved, 0)
+	}
+	return used
+}
+
+// q.mu must be held
+func (q *Queue) start(cq *clientQueue) {
+	q.running++
+	cq.class.running++
+	cq.running++
+}
+
+func (q *Queue) releaseFunc(cq *clientQueue) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { q.release(cq) })
+	}
+}
+
+func (q *Queue) release(cq *clientQueue) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	cq.class.running--
+	cq.running--
+	q.cleanup(cq)
+	q.dispatch()
+}
+
+// hands free slots to the waiting clients in round-robin order
+// q.mu must be held
+func (q *Queue) dispatch() {
+	granted := false
+	for e := q.ring.Front(); e != nil; {
+		cq := e.Value.(*clientQueue)
+		if !q.canStart(cq) {
+			e = e.Next()
+			continue
+		}
+
+		w := cq.waiting.Remove(cq.waiting.Front()).(*waiter)
+		q.waiting--
+		q.start(cq)
+		close(w.ready)
+		granted = true
+
+		// the served client moves to the back of the ring
+		if cq.waiting.Len() == 0 {
+			q.ring.Remove(e)
+			cq.ring_elem = nil
+		} else {
+			q.ring.MoveToBack(e)
+		}
+		e = q.ring.Front()
+	}
+	if granted {
+		q.notifyPositions()
+	}
+}
+
+// sends every waiter its estimated position,
+// assuming that every client is served in turn
+// q.mu must be held
+func (q *Queue) notifyPositions() {
+	cursors := make([]*list.Element, 0, q.ring.Len())
+	for e := q.ring.Front(); e != nil; e = e.Next() {
+		cursors = append(cursors, e.Value.(*clientQueue).waiting.Front())
+	}
+
+	pos := 1
+	for remaining := q.waiting; remaining > 0; {
+		for i, elem := range cursors {
+			if elem == nil {
+				continue
+			}
+			w := elem.Value.(*waiter)
+			// drop a position that was not yet received
+			select {
+			case <-w.position:
+			default:
+			}
+			w.position <- pos
+			pos++
+			remaining--
+			cursors[i] = elem.Next()
+		}
+	}
+}
+
+// number of jobs waiting for a slot
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiting
+}
+
+// number of occupied slots
+func (q *Queue) Running() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running
+}
+
+// reports whether a new job would be rejected with ErrQueueFull
+func (q *Queue) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running >= q.cfg.Slots && q.waiting >= q.cfg.MaxQueued
+}
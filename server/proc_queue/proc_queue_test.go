@@ -0,0 +1,157 @@
+package procqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueFIFO(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := New(Config{Slots: 1, MaxQueued: 2})
+	assert.NoError(err)
+	client := Client{ID: "a"}
+
+	release, err := q.Acquire(context.Background(), client, nil)
+	assert.NoError(err)
+
+	order := make(chan int, 2)
+	positions := make(chan int, 10)
+	for i := range 2 {
+		go func() {
+			rel, err := q.Acquire(context.Background(), client, func(pos int) {
+				if i == 1 {
+					positions <- pos
+				}
+			})
+			assert.NoError(err)
+			order <- i
+			rel()
+		}()
+		// make sure the goroutines are queued in order
+		assert.Eventually(func() bool { return q.Len() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	assert.True(q.Full())
+	_, err = q.Acquire(context.Background(), client, nil)
+	assert.ErrorIs(err, ErrQueueFull)
+
+	assert.Equal(2, <-positions)
+	release()
+	assert.Equal(0, <-order)
+	assert.Equal(1, <-positions)
+	assert.Equal(1, <-order)
+	assert.Equal(0, q.Running())
+}
+
+func TestQueueCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := New(Config{Slots: 1, MaxQueued: 1})
+	assert.NoError(err)
+
+	release, err := q.Acquire(context.Background(), Client{ID: "a"}, nil)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.Acquire(ctx, Client{ID: "b"}, nil)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(0, q.Len())
+
+	release()
+	release() // releasing twice must not free a second slot
+	assert.Equal(0, q.Running())
+}
+
+func TestQueueRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := New(Config{Slots: 1, MaxQueued: 10})
+	assert.NoError(err)
+
+	release, err := q.Acquire(context.Background(), Client{ID: "busy"}, nil)
+	assert.NoError(err)
+
+	// "a" queues three jobs before "b" queues one
+	order := make(chan string, 4)
+	for i, id := range []string{"a", "a", "a", "b"} {
+		go func() {
+			rel, err := q.Acquire(context.Background(), Client{ID: id}, nil)
+			assert.NoError(err)
+			order <- id
+			rel()
+		}()
+		assert.Eventually(func() bool { return q.Len() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	release()
+	var got []string
+	for range 4 {
+		got = append(got, <-order)
+	}
+	assert.Equal([]string{"a", "b", "a", "a"}, got)
+}
+
+func TestQueueClientLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := New(Config{Slots: 4, MaxQueued: 10, MaxPerClient: 1, MaxQueuedPerClient: 1})
+	assert.NoError(err)
+	client := Client{ID: "a"}
+
+	release, err := q.Acquire(context.Background(), client, nil)
+	assert.NoError(err)
+
+	// a second job of the same client is queued although slots are free
+	done := make(chan struct{})
+	go func() {
+		rel, err := q.Acquire(context.Background(), client, nil)
+		assert.NoError(err)
+		rel()
+		close(done)
+	}()
+	assert.Eventually(func() bool { return q.Len() == 1 }, time.Second, time.Millisecond)
+
+	_, err = q.Acquire(context.Background(), client, nil)
+	assert.ErrorIs(err, ErrClientLimit)
+
+	// other clients are not affected
+	other, err := q.Acquire(context.Background(), Client{ID: "b"}, nil)
+	assert.NoError(err)
+	other()
+
+	release()
+	<-done
+	assert.Equal(0, q.Running())
+}
+
+func TestQueueReservedClasses(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(Config{Slots: 2, Classes: []Class{{Name: "workshop", Reserved: 3}}})
+	assert.Error(err)
+
+	q, err := New(Config{Slots: 2, MaxQueued: 0, Classes: []Class{{Name: "workshop", Reserved: 1}}})
+	assert.NoError(err)
+
+	release, err := q.Acquire(context.Background(), Client{ID: "a"}, nil)
+	assert.NoError(err)
+
+	// the only shared slot is used, the reserved one is not available for the default class
+	_, err = q.Acquire(context.Background(), Client{ID: "b"}, nil)
+	assert.ErrorIs(err, ErrQueueFull)
+
+	workshop, err := q.Acquire(context.Background(), Client{ID: "b", Class: "workshop"}, nil)
+	assert.NoError(err)
+
+	_, err = q.Acquire(context.Background(), Client{ID: "c", Class: "unknown"}, nil)
+	assert.Error(err)
+
+	workshop()
+	release()
+	assert.Equal(0, q.Running())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	"github.com/DDP-Projekt/Spielplatz/server/profile"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+type ProfileRequest struct {
+	Src   string   `json:"src"`
+	Args  []string `json:"args"`
+	Stdin string   `json:"stdin"`
+}
+
+type ProfileResponse struct {
+	Compilation kddp.ProgramResult[executables.TokenType] `json:"compilation"`
+	ExitCode    int                                       `json:"exitCode"`
+	Error       *string                                   `json:"error"` // set if the run or the evaluation failed
+	Profile     *profile.Profile                          `json:"profile"`
+}
+
+// serves the /profile endpoint
+// compiles the program, runs it once under perf and returns where the time was spent
+func serve_profile(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req ProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("unmarshaling request", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("compiling program for profiling")
+	exe_path, compilation, cleanup, ok := compileOnce(c, logger, req.Src)
+	if !ok {
+		return
+	}
+	defer cleanup()
+	response := ProfileResponse{Compilation: compilation, ExitCode: -1}
+	if compilation.Error != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	fail := func(err error) {
+		err_string := err.Error()
+		response.Error = &err_string
+		c.JSON(http.StatusOK, response)
+	}
+
+	data_file, err := os.CreateTemp(executables.Dir(), "Spielplatz_*.perf")
+	if err != nil {
+		logger.Error("failed to create profile data file", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	data_file.Close()
+	if err := executables.ReserveScratch(data_file.Name(), viper.GetInt64("profile_max_data_bytes")); err != nil {
+		logger.Warn("no space for the profile data", "err", err)
+		os.Remove(data_file.Name())
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer executables.RemoveScratch(data_file.Name())
+
+	result, err := kddp.RunExecutable(c.Request.Context(), exe_path, strings.NewReader(req.Stdin), io.Discard, io.Discard, kddp.RunOptions{
+		Args:          req.Args,
+		Client:        processClient(c),
+		Timeout:       viper.GetDuration("profile_run_timeout"),
+		ProfileOutput: data_file.Name(),
+	}, logger)
+	if isBusyErr(err) {
+		logger.Warn("no process slot for profiling", "err", err)
+		serveBusy(c, err)
+		return
+	}
+	err = kddp.IgnoreExitCode(result, err)
+	response.ExitCode = result.ExitCode
+	if err != nil {
+		logger.Info("profiled run failed", "err", err)
+		fail(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), viper.GetDuration("profile_script_timeout"))
+	defer cancel()
+	stacks, err := profile.Script(ctx, viper.GetString("perf_path"), data_file.Name(), viper.GetInt64("profile_max_script_bytes"))
+	if err != nil {
+		logger.Error("failed to evaluate profile", "err", err)
+		fail(errors.New("Das Profil konnte nicht ausgewertet werden"))
+		return
+	}
+
+	p := profile.Build(stacks)
+	response.Profile = &p
+	logger.Info("profiling finished", "samples", p.Samples)
+	c.JSON(http.StatusOK, response)
+}
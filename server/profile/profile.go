@@ -0,0 +1,179 @@
+/*
+package profile turns the samples of `perf record` into a flat profile
+and a call tree that can be rendered as flame graph
+*/
+package profile
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// the DDP main program as named in the executable
+const mainSymbol = "ddp_ddpmain"
+
+// a function with the number of samples in which it was running (self)
+// or on the call stack (total)
+type Function struct {
+	Name         string  `json:"name"`
+	Self         int     `json:"selfSamples"`
+	Total        int     `json:"totalSamples"`
+	SelfPercent  float64 `json:"selfPercent"`
+	TotalPercent float64 `json:"totalPercent"`
+}
+
+// a node of the call tree in the format of d3-flame-graph
+type Node struct {
+	Name     string  `json:"name"`
+	Value    int     `json:"value"` // number of samples in this node and its children
+	Children []*Node `json:"children"`
+}
+
+type Profile struct {
+	Samples int        `json:"samples"`
+	Flat    []Function `json:"flat"` // sorted by self samples
+	Tree    *Node      `json:"tree"`
+}
+
+// runs `perf script` on the recorded data and returns the sampled call stacks
+func Script(ctx context.Context, perf_path, data_path string, max_size int64) ([][]string, error) {
+	cmd := exec.CommandContext(ctx, perf_path, "script", "-i", data_path, "-F", "comm,ip,sym")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting perf script: %w", err)
+	}
+	stacks, parse_err := Parse(io.LimitReader(stdout, max_size))
+	// perf might still be writing if the output was too large
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("perf script failed: %w", err)
+	}
+	return stacks, parse_err
+}
+
+// parses the output of `perf script -F comm,ip,sym`
+// every returned stack starts with the outermost function
+func Parse(r io.Reader) ([][]string, error) {
+	var stacks [][]string
+	var current []string
+	skip := false
+	flush := func() {
+		if !skip && len(current) > 0 {
+			slices.Reverse(current)
+			stacks = append(stacks, current)
+		}
+		current, skip = nil, false
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		// sample headers are not indented, frames are
+		if line[0] != ' ' && line[0] != '\t' {
+			flush()
+			// samples taken before perf executed the program
+			skip = strings.TrimSpace(line) == "perf-exec"
+			continue
+		}
+		fields := strings.Fields(line)
+		sym := "[unknown]"
+		if len(fields) > 1 {
+			sym = strings.Join(fields[1:], " ")
+		}
+		current = append(current, sym)
+	}
+	flush()
+	return stacks, scanner.Err()
+}
+
+// the name shown for a symbol
+func FunctionName(sym string) string {
+	if i := strings.LastIndex(sym, "+0x"); i > 0 {
+		sym = sym[:i]
+	}
+	switch sym {
+	case mainSymbol:
+		return "Hauptprogramm"
+	case "[unknown]":
+		return "[unbekannt]"
+	}
+	return sym
+}
+
+// builds the profile from stacks as returned by Parse
+// frames outside of the DDP main program (e.g. libc startup code) are removed
+func Build(stacks [][]string) Profile {
+	root := &Node{Name: "Gesamt", Children: []*Node{}}
+	functions := map[string]*Function{}
+	function := func(name string) *Function {
+		f, ok := functions[name]
+		if !ok {
+			f = &Function{Name: name}
+			functions[name] = f
+		}
+		return f
+	}
+
+	for _, stack := range stacks {
+		names := make([]string, 0, len(stack))
+		for _, sym := range stack {
+			names = append(names, FunctionName(sym))
+		}
+		if i := slices.Index(names, FunctionName(mainSymbol)); i > 0 {
+			names = names[i:]
+		}
+
+		root.Value++
+		node := root
+		seen := map[string]bool{}
+		for _, name := range names {
+			node = child(node, name)
+			node.Value++
+			// recursive functions are counted once per sample
+			if !seen[name] {
+				seen[name] = true
+				function(name).Total++
+			}
+		}
+		if len(names) > 0 {
+			function(names[len(names)-1]).Self++
+		}
+	}
+
+	flat := make([]Function, 0, len(functions))
+	for _, f := range functions {
+		if root.Value > 0 {
+			f.SelfPercent = 100 * float64(f.Self) / float64(root.Value)
+			f.TotalPercent = 100 * float64(f.Total) / float64(root.Value)
+		}
+		flat = append(flat, *f)
+	}
+	slices.SortFunc(flat, func(a, b Function) int {
+		return cmp.Or(cmp.Compare(b.Self, a.Self), cmp.Compare(b.Total, a.Total), strings.Compare(a.Name, b.Name))
+	})
+	return Profile{Samples: root.Value, Flat: flat, Tree: root}
+}
+
+func child(node *Node, name string) *Node {
+	for _, c := range node.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &Node{Name: name, Children: []*Node{}}
+	node.Children = append(node.Children, c)
+	return c
+}
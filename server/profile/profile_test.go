@@ -0,0 +1,62 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const script = `perf-exec
+	ffffffff81000000 [unknown]
+
+Spielplatz_1
+	401a00 fib+0x10
+	401a40 fib+0x50
+	401b00 ddp_ddpmain+0x20
+	401c00 main+0x30
+	401d00 __libc_start_main+0x80
+
+Spielplatz_1
+	401e00 ddp_string_from_constant+0x5
+	401b00 ddp_ddpmain+0x20
+	401c00 main+0x30
+
+Spielplatz_1
+	401a40 fib+0x50
+	401b00 ddp_ddpmain+0x20
+	401c00 main+0x30
+`
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	stacks, err := Parse(strings.NewReader(script))
+	assert.NoError(err)
+	assert.Len(stacks, 3)
+	assert.Equal([]string{"__libc_start_main+0x80", "main+0x30", "ddp_ddpmain+0x20", "fib+0x50", "fib+0x10"}, stacks[0])
+}
+
+func TestBuild(t *testing.T) {
+	assert := assert.New(t)
+
+	stacks, err := Parse(strings.NewReader(script))
+	assert.NoError(err)
+	p := Build(stacks)
+
+	assert.Equal(3, p.Samples)
+	assert.Equal(Function{Name: "fib", Self: 2, Total: 2, SelfPercent: 200.0 / 3, TotalPercent: 200.0 / 3}, p.Flat[0])
+	assert.Equal("ddp_string_from_constant", p.Flat[1].Name)
+	assert.Equal(Function{Name: "Hauptprogramm", Self: 0, Total: 3, TotalPercent: 100}, p.Flat[2])
+	assert.Len(p.Flat, 3)
+
+	assert.Equal(3, p.Tree.Value)
+	assert.Len(p.Tree.Children, 1)
+	main := p.Tree.Children[0]
+	assert.Equal("Hauptprogramm", main.Name)
+	assert.Equal(3, main.Value)
+	assert.Equal("fib", main.Children[0].Name)
+	assert.Equal(2, main.Children[0].Value)
+	assert.Equal("fib", main.Children[0].Children[0].Name)
+	assert.Equal(1, main.Children[0].Children[0].Value)
+}
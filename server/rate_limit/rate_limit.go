@@ -0,0 +1,83 @@
+/*
+package ratelimit counts events per key (e.g. the ip of a client) in fixed time windows
+*/
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type counter struct {
+	count int
+	reset time.Time // the end of the current window
+}
+
+// allows max events per key in every window
+type Limiter struct {
+	max        int
+	window     time.Duration
+	mutex      sync.Mutex
+	counters   map[string]*counter
+	last_sweep time.Time
+}
+
+// a limiter with max <= 0 allows everything
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:      max,
+		window:   window,
+		counters: map[string]*counter{},
+	}
+}
+
+// counts an event of key and reports whether it is allowed
+func (l *Limiter) Allow(key string) bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	c := l.counterLocked(key, time.Now())
+	if c.count >= l.max {
+		return false
+	}
+	c.count++
+	return true
+}
+
+// reports whether key used up its events in the current window without counting one
+func (l *Limiter) Exceeded(key string) bool {
+	if l.max <= 0 {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.counterLocked(key, time.Now()).count >= l.max
+}
+
+// counts an event of key, used together with Exceeded to only count failures
+func (l *Limiter) Add(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.counterLocked(key, time.Now()).count++
+}
+
+// must be called with mutex held
+func (l *Limiter) counterLocked(key string, now time.Time) *counter {
+	// forget keys whose window ended, so that the map does not grow forever
+	if now.Sub(l.last_sweep) > l.window {
+		for k, c := range l.counters {
+			if !now.Before(c.reset) {
+				delete(l.counters, k)
+			}
+		}
+		l.last_sweep = now
+	}
+	c, ok := l.counters[key]
+	if !ok || !now.Before(c.reset) {
+		c = &counter{reset: now.Add(l.window)}
+		l.counters[key] = c
+	}
+	return c
+}
@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	assert := assert.New(t)
+
+	l := New(2, 50*time.Millisecond)
+	assert.True(l.Allow("a"))
+	assert.True(l.Allow("a"))
+	assert.False(l.Allow("a"))
+	assert.True(l.Allow("b"), "keys are counted separately")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(l.Allow("a"), "a new window started")
+}
+
+func TestExceeded(t *testing.T) {
+	assert := assert.New(t)
+
+	l := New(1, time.Minute)
+	assert.False(l.Exceeded("a"))
+	assert.False(l.Exceeded("a"), "Exceeded does not count")
+	l.Add("a")
+	assert.True(l.Exceeded("a"))
+
+	assert.True(New(0, time.Minute).Allow("a"), "0 means unlimited")
+}
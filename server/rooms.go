@@ -0,0 +1,425 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	ratelimit "github.com/DDP-Projekt/Spielplatz/server/rate_limit"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// a classroom room that collects student submissions
+type Room struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	JoinCode         string    `json:"joinCode"`
+	teacherTokenHash []byte    // sha256 of the teacher token
+	CreatedAt        time.Time `json:"createdAt"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+type RoomSubmission struct {
+	ID          string    `json:"id"`
+	StudentName string    `json:"studentName"`
+	Code        string    `json:"code"`
+	Output      *string   `json:"output"` // null if no run output was submitted
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+const createRoomsTablesSQL = `
+CREATE TABLE IF NOT EXISTS rooms (
+	uuid TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	join_code TEXT NOT NULL UNIQUE,
+	teacher_token_hash BLOB NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS room_submissions (
+	uuid TEXT PRIMARY KEY,
+	room_uuid TEXT NOT NULL,
+	student_name TEXT NOT NULL,
+	compressed_code BLOB NOT NULL,
+	compressed_output BLOB,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS room_submissions_room ON room_submissions (room_uuid, created_at);
+`
+
+// characters used in join codes, without easily confused ones like 0/O and 1/I
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const joinCodeLength = 6
+
+var (
+	errRoomNotFound = errors.New("room not found")
+	errRoomFull     = errors.New("room is full")
+)
+
+var (
+	// counts the wrong join codes per client, so that join codes cannot be guessed
+	roomJoinLimiter *ratelimit.Limiter
+	// limits how many rooms a single client creates
+	roomCreateLimiter *ratelimit.Limiter
+)
+
+// creates the room tables and starts deleting expired rooms
+// must be called after initShareLinksStorage
+func initRooms() error {
+	if _, err := shareLinksDB.Exec(createRoomsTablesSQL); err != nil {
+		return err
+	}
+	roomJoinLimiter = ratelimit.New(viper.GetInt("room_join_attempts"), viper.GetDuration("room_join_window"))
+	roomCreateLimiter = ratelimit.New(viper.GetInt("rooms_per_client"), viper.GetDuration("rooms_window"))
+	go func() {
+		for {
+			deleteExpiredRooms()
+			time.Sleep(viper.GetDuration("room_cleanup_interval"))
+		}
+	}()
+	return nil
+}
+
+func deleteExpiredRooms() {
+	now := time.Now().UTC()
+	if _, err := shareLinksDB.Exec(
+		"DELETE FROM room_submissions WHERE room_uuid IN (SELECT uuid FROM rooms WHERE expires_at < ?)", now,
+	); err != nil {
+		slog.Warn("failed to delete submissions of expired rooms", "err", err)
+		return
+	}
+	if _, err := shareLinksDB.Exec(
+		"DELETE FROM exercise_submissions WHERE room_uuid IN (SELECT uuid FROM rooms WHERE expires_at < ?)", now,
+	); err != nil {
+		slog.Warn("failed to delete exercise submissions of expired rooms", "err", err)
+		return
+	}
+	res, err := shareLinksDB.Exec("DELETE FROM rooms WHERE expires_at < ?", now)
+	if err != nil {
+		slog.Warn("failed to delete expired rooms", "err", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("deleted expired rooms", "count", n)
+	}
+}
+
+func generateJoinCode() (string, error) {
+	code := make([]byte, joinCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(joinCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func hashTeacherToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// creates a new room and returns it together with the teacher token
+func createRoom(name string, duration time.Duration) (Room, string, error) {
+	token_bytes := make([]byte, 32)
+	if _, err := rand.Read(token_bytes); err != nil {
+		return Room{}, "", err
+	}
+	token := hex.EncodeToString(token_bytes)
+
+	now := time.Now().UTC()
+	room := Room{
+		ID:               uuid.NewString(),
+		Name:             name,
+		teacherTokenHash: hashTeacherToken(token),
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(duration),
+	}
+
+	// retry in the unlikely case of a join code collision
+	var err error
+	for range 5 {
+		if room.JoinCode, err = generateJoinCode(); err != nil {
+			return Room{}, "", err
+		}
+		_, err = shareLinksDB.Exec(
+			"INSERT INTO rooms (uuid, name, join_code, teacher_token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+			room.ID, room.Name, room.JoinCode, room.teacherTokenHash, room.CreatedAt, room.ExpiresAt,
+		)
+		if err == nil {
+			return room, token, nil
+		}
+	}
+	return Room{}, "", err
+}
+
+// returns the room if it exists and is not expired
+func getRoom(query string, arg any) (Room, error) {
+	var room Room
+	err := shareLinksDB.QueryRow(
+		"SELECT uuid, name, join_code, teacher_token_hash, created_at, expires_at FROM rooms WHERE "+query+" AND expires_at >= ?",
+		arg, time.Now().UTC(),
+	).Scan(&room.ID, &room.Name, &room.JoinCode, &room.teacherTokenHash, &room.CreatedAt, &room.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return Room{}, errRoomNotFound
+	}
+	return room, err
+}
+
+// stores the submission unless the room already has max_submissions, then errRoomFull is returned
+func storeRoomSubmission(room_id string, max_submissions int, student_name, code string, output *string) (RoomSubmission, error) {
+	submission := RoomSubmission{
+		ID:          uuid.NewString(),
+		StudentName: student_name,
+		Code:        code,
+		Output:      output,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	var compressed_output []byte
+	if output != nil {
+		compressed_output = zstdEncoder.EncodeAll([]byte(*output), nil)
+	}
+	// counting and inserting in one statement, so that concurrent submissions cannot exceed the limit
+	res, err := shareLinksDB.Exec(
+		`INSERT INTO room_submissions (uuid, room_uuid, student_name, compressed_code, compressed_output, created_at)
+		SELECT ?, ?, ?, ?, ?, ? WHERE (SELECT COUNT(*) FROM room_submissions WHERE room_uuid = ?) < ?`,
+		submission.ID, room_id, student_name, zstdEncoder.EncodeAll([]byte(code), nil), compressed_output, submission.CreatedAt,
+		room_id, max_submissions,
+	)
+	if err != nil {
+		return RoomSubmission{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return RoomSubmission{}, err
+	} else if n == 0 {
+		return RoomSubmission{}, errRoomFull
+	}
+	return submission, nil
+}
+
+// all submissions of a room, oldest first
+func getRoomSubmissions(room_id string) ([]RoomSubmission, error) {
+	rows, err := shareLinksDB.Query(
+		"SELECT uuid, student_name, compressed_code, compressed_output, created_at FROM room_submissions WHERE room_uuid = ? ORDER BY created_at",
+		room_id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	submissions := []RoomSubmission{}
+	for rows.Next() {
+		var (
+			submission        RoomSubmission
+			compressed_code   []byte
+			compressed_output []byte
+		)
+		if err := rows.Scan(&submission.ID, &submission.StudentName, &compressed_code, &compressed_output, &submission.CreatedAt); err != nil {
+			return nil, err
+		}
+		code, err := zstdDecoder.DecodeAll(compressed_code, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing submission %s: %w", submission.ID, err)
+		}
+		submission.Code = string(code)
+		if compressed_output != nil {
+			output, err := zstdDecoder.DecodeAll(compressed_output, nil)
+			if err != nil {
+				return nil, fmt.Errorf("decompressing output of submission %s: %w", submission.ID, err)
+			}
+			submission.Output = new(string)
+			*submission.Output = string(output)
+		}
+		submissions = append(submissions, submission)
+	}
+	return submissions, rows.Err()
+}
+
+type CreateRoomRequest struct {
+	Name string `json:"name"`
+	// lifetime of the room in hours, 0 means room_default_duration
+	DurationHours int `json:"durationHours"`
+}
+
+// serves the POST /rooms endpoint
+func serve_create_room(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req CreateRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("failed to bind json", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Der Name des Raums muss zwischen 1 und 100 Zeichen lang sein"})
+		return
+	}
+
+	if !roomCreateLimiter.Allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Zu viele Räume erstellt, bitte später erneut versuchen"})
+		return
+	}
+
+	duration := viper.GetDuration("room_default_duration")
+	if req.DurationHours > 0 {
+		duration = time.Duration(req.DurationHours) * time.Hour
+	}
+	duration = min(duration, viper.GetDuration("room_max_duration"))
+
+	room, token, err := createRoom(req.Name, duration)
+	if err != nil {
+		logger.Error("failed to create room", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create room"})
+		return
+	}
+
+	logger.Info("created room", "room", room.ID, "expires_at", room.ExpiresAt)
+	c.JSON(http.StatusOK, gin.H{
+		"room":         room,
+		"teacherToken": token,
+	})
+}
+
+type SubmitToRoomRequest struct {
+	JoinCode    string  `json:"joinCode"`
+	StudentName string  `json:"studentName"`
+	Code        string  `json:"code"`
+	Output      *string `json:"output"` // optional run output
+}
+
+// serves the POST /rooms/submit endpoint
+func serve_submit_to_room(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req SubmitToRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("failed to bind json", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.StudentName = strings.TrimSpace(req.StudentName)
+	if !validStudentName(c, req.StudentName) {
+		return
+	}
+	size := len(req.Code)
+	if req.Output != nil {
+		size += len(*req.Output)
+	}
+	if size > viper.GetInt("max_room_submission_bytes") {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Die Abgabe ist zu groß"})
+		return
+	}
+
+	room, ok := joinRoom(c, req.JoinCode)
+	if !ok {
+		return
+	}
+	logger = logger.With("room", room.ID)
+
+	submission, err := storeRoomSubmission(room.ID, viper.GetInt("max_room_submissions"), req.StudentName, req.Code, req.Output)
+	if err == errRoomFull {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Der Raum nimmt keine weiteren Abgaben an"})
+		return
+	}
+	if err != nil {
+		logger.Error("failed to store submission", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store submission"})
+		return
+	}
+
+	logger.Info("stored room submission", "submission", submission.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"submissionId": submission.ID,
+		"roomName":     room.Name,
+		"createdAt":    submission.CreatedAt,
+	})
+}
+
+// writes an error response and returns false if name is empty or too long
+func validStudentName(c *gin.Context, name string) bool {
+	if name == "" || len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Der Name muss zwischen 1 und 100 Zeichen lang sein"})
+		return false
+	}
+	return true
+}
+
+// loads the room with the given join code, wrong codes count towards roomJoinLimiter
+// writes an error response and returns false if the room does not exist
+func joinRoom(c *gin.Context, join_code string) (Room, bool) {
+	if roomJoinLimiter.Exceeded(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Zu viele falsche Raum-Codes, bitte später erneut versuchen"})
+		return Room{}, false
+	}
+	room, err := getRoom("join_code = ?", strings.ToUpper(strings.TrimSpace(join_code)))
+	if err == errRoomNotFound {
+		roomJoinLimiter.Add(c.ClientIP())
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unbekannter oder abgelaufener Raum-Code"})
+		return Room{}, false
+	}
+	if err != nil {
+		getLogger(c).Error("failed to load room", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return Room{}, false
+	}
+	return room, true
+}
+
+// loads the room from the :id parameter and checks the teacher token from the Authorization: Bearer header
+// the token is never accepted in the url, where it would end up in logs, the browser history and the Referer
+// writes an error response and returns false if the request is not authorized
+func authorizeTeacher(c *gin.Context) (Room, bool) {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	room, err := getRoom("uuid = ?", c.Param("id"))
+	if err == errRoomNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unbekannter oder abgelaufener Raum"})
+		return Room{}, false
+	}
+	if err != nil {
+		getLogger(c).Error("failed to load room", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load room"})
+		return Room{}, false
+	}
+	if subtle.ConstantTimeCompare(hashTeacherToken(token), room.teacherTokenHash) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Ungültiges Lehrer-Token"})
+		return Room{}, false
+	}
+	return room, true
+}
+
+// serves the GET /rooms/:id/submissions endpoint
+func serve_room_submissions(c *gin.Context) {
+	room, ok := authorizeTeacher(c)
+	if !ok {
+		return
+	}
+
+	submissions, err := getRoomSubmissions(room.ID)
+	if err != nil {
+		getLogger(c).Error("failed to load submissions", "err", err, "room", room.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load submissions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"room":        room,
+		"submissions": submissions,
+	})
+}
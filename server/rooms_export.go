@@ -0,0 +1,210 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the page shown by serve_room_view, it loads the submissions with the token from the fragment
+const roomViewHTML = `<!DOCTYPE html>
+<html lang="de">
+<head>
+	<meta charset="utf-8">
+	<title>Abgaben</title>
+	<style>
+		body { font-family: sans-serif; margin: 2em; }
+		pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+		.meta { color: #666; }
+		.error { color: #b00; }
+	</style>
+</head>
+<body>
+	<h1 id="name">Abgaben</h1>
+	<p class="meta" id="meta"></p>
+	<form id="login" hidden>
+		<label>Lehrer-Token: <input type="password" id="token" autocomplete="off"></label>
+		<button>Anzeigen</button>
+	</form>
+	<p><button id="export" hidden>Alle Abgaben herunterladen</button></p>
+	<p class="error" id="error"></p>
+	<div id="submissions"></div>
+	<script>
+		// the fragment is never sent to the server, it is removed from the address bar as well
+		let token = new URLSearchParams(location.hash.slice(1)).get("token");
+		history.replaceState(null, "", location.pathname);
+
+		const $ = (id) => document.getElementById(id);
+		const date = (s) => new Date(s).toLocaleString("de-DE");
+		function element(tag, text, className) {
+			const e = document.createElement(tag);
+			e.textContent = text;
+			if (className) e.className = className;
+			return e;
+		}
+
+		async function get(path) {
+			const response = await fetch(path, { headers: { Authorization: "Bearer " + token }, cache: "no-store" });
+			if (!response.ok) {
+				const body = await response.json().catch(() => ({}));
+				throw new Error(body.error || response.statusText);
+			}
+			return response;
+		}
+
+		async function load() {
+			$("error").textContent = "";
+			try {
+				const { room, submissions } = await (await get("submissions")).json();
+				$("login").hidden = true;
+				$("export").hidden = false;
+				document.title = room.name + " - Abgaben";
+				$("name").textContent = room.name;
+				$("meta").replaceChildren("Raum-Code: ", element("b", room.joinCode), ", läuft ab am " + date(room.expiresAt));
+				const sections = submissions.map((submission) => {
+					const section = document.createElement("section");
+					section.append(
+						element("h2", submission.studentName),
+						element("p", "abgegeben am " + date(submission.createdAt), "meta"),
+						element("pre", submission.code),
+					);
+					if (submission.output) {
+						section.append(element("h3", "Ausgabe"), element("pre", submission.output));
+					}
+					return section;
+				});
+				$("submissions").replaceChildren(...(sections.length > 0 ? sections : [element("p", "Noch keine Abgaben.")]));
+			} catch (err) {
+				$("login").hidden = false;
+				$("error").textContent = err.message;
+			}
+		}
+
+		$("login").addEventListener("submit", (event) => {
+			event.preventDefault();
+			token = $("token").value.trim();
+			load();
+		});
+		$("export").addEventListener("click", async () => {
+			try {
+				const response = await get("export");
+				const match = /filename="(.*)"/.exec(response.headers.get("Content-Disposition") || "");
+				const url = URL.createObjectURL(await response.blob());
+				const link = element("a", "");
+				link.href = url;
+				link.download = match ? match[1] : "abgaben.zip";
+				link.click();
+				URL.revokeObjectURL(url);
+			} catch (err) {
+				$("error").textContent = err.message;
+			}
+		});
+
+		if (token) {
+			load();
+		} else {
+			$("login").hidden = false;
+		}
+	</script>
+</body>
+</html>
+`
+
+// serves the GET /rooms/:id/view endpoint
+// a simple html page listing all submissions for the teacher
+// the page itself is the same for every room, it sends the token from the fragment (view#token=<token>)
+// in the Authorization header, so that the token never ends up in a url the server, proxies or the Referer see
+func serve_room_view(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(roomViewHTML))
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^\p{L}\p{N}_-]+`)
+
+// serves the GET /rooms/:id/export endpoint
+// sends a zip archive with every submission and an index.json
+func serve_room_export(c *gin.Context) {
+	logger := getLogger(c)
+	room, ok := authorizeTeacher(c)
+	if !ok {
+		return
+	}
+
+	submissions, err := getRoomSubmissions(room.ID)
+	if err != nil {
+		logger.Error("failed to load submissions", "err", err, "room", room.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load submissions"})
+		return
+	}
+
+	file_name := unsafeFileNameChars.ReplaceAllString(room.Name, "_") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file_name))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	if err := writeRoomArchive(archive, room, submissions); err != nil {
+		logger.Error("failed to write room archive", "err", err, "room", room.ID)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		logger.Error("failed to finish room archive", "err", err, "room", room.ID)
+	}
+}
+
+func writeRoomArchive(archive *zip.Writer, room Room, submissions []RoomSubmission) error {
+	type indexEntry struct {
+		StudentName string    `json:"studentName"`
+		CreatedAt   time.Time `json:"createdAt"`
+		CodeFile    string    `json:"codeFile"`
+		OutputFile  string    `json:"outputFile,omitempty"`
+	}
+
+	writeFile := func(name string, modified time.Time, content []byte) error {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+
+	index := make([]indexEntry, 0, len(submissions))
+	for i, submission := range submissions {
+		base := fmt.Sprintf("%03d_%s_%s",
+			i+1,
+			submission.CreatedAt.Local().Format("2006-01-02_15-04-05"),
+			unsafeFileNameChars.ReplaceAllString(submission.StudentName, "_"),
+		)
+		entry := indexEntry{
+			StudentName: submission.StudentName,
+			CreatedAt:   submission.CreatedAt,
+			CodeFile:    base + ".ddp",
+		}
+		if err := writeFile(entry.CodeFile, submission.CreatedAt, []byte(submission.Code)); err != nil {
+			return err
+		}
+		if submission.Output != nil {
+			entry.OutputFile = base + "_ausgabe.txt"
+			if err := writeFile(entry.OutputFile, submission.CreatedAt, []byte(*submission.Output)); err != nil {
+				return err
+			}
+		}
+		index = append(index, entry)
+	}
+
+	index_json, err := json.MarshalIndent(gin.H{
+		"room":        room,
+		"submissions": index,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile("index.json", time.Now(), index_json)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ratelimit "github.com/DDP-Projekt/Spielplatz/server/rate_limit"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a fresh rooms database and the room endpoints as registered in main
+func setupRooms(t *testing.T) *gin.Engine {
+	initCompression()
+	old_db, old_join_limiter, old_create_limiter := shareLinksDB, roomJoinLimiter, roomCreateLimiter
+	require.NoError(t, initShareLinksStorage(filepath.Join(t.TempDir(), "rooms.db")))
+	_, err := shareLinksDB.Exec(createRoomsTablesSQL)
+	require.NoError(t, err)
+	require.NoError(t, createExerciseSubmissionsTable())
+	roomJoinLimiter = ratelimit.New(3, time.Minute)
+	roomCreateLimiter = ratelimit.New(3, time.Minute)
+	t.Cleanup(func() {
+		shareLinksDB.Close()
+		shareLinksDB, roomJoinLimiter, roomCreateLimiter = old_db, old_join_limiter, old_create_limiter
+	})
+	setConfig(t, "room_default_duration", time.Hour)
+	setConfig(t, "room_max_duration", time.Hour)
+	setConfig(t, "max_room_submissions", 2)
+	setConfig(t, "max_room_submission_bytes", 1024)
+
+	r := testRouter()
+	r.POST("/rooms", serve_create_room)
+	r.POST("/rooms/submit", serve_submit_to_room)
+	r.GET("/rooms/:id/submissions", serve_room_submissions)
+	r.GET("/rooms/:id/exercises", serve_room_exercise_submissions)
+	r.GET("/rooms/:id/view", serve_room_view)
+	return r
+}
+
+func createTestRoom(t *testing.T, r *gin.Engine) (Room, string) {
+	w := request(r, http.MethodPost, "/rooms", gin.H{"name": "7b"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Room         Room   `json:"room"`
+		TeacherToken string `json:"teacherToken"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Room, resp.TeacherToken
+}
+
+func TestJoinCode(t *testing.T) {
+	assert := assert.New(t)
+	for range 100 {
+		code, err := generateJoinCode()
+		assert.NoError(err)
+		assert.Len(code, joinCodeLength)
+		for _, r := range code {
+			assert.Contains(joinCodeAlphabet, string(r))
+		}
+	}
+}
+
+func TestRoomSubmissions(t *testing.T) {
+	assert := assert.New(t)
+	r := setupRooms(t)
+	room, token := createTestRoom(t, r)
+
+	// join codes are case insensitive
+	submit := gin.H{"joinCode": " " + strings.ToLower(room.JoinCode), "studentName": "Anna", "code": "Schreibe 1."}
+	assert.Equal(http.StatusOK, request(r, http.MethodPost, "/rooms/submit", submit, nil).Code)
+	assert.Equal(http.StatusOK, request(r, http.MethodPost, "/rooms/submit", submit, nil).Code)
+	assert.Equal(http.StatusForbidden, request(r, http.MethodPost, "/rooms/submit", submit, nil).Code, "max_room_submissions")
+
+	w := request(r, http.MethodGet, "/rooms/"+room.ID+"/submissions", nil, http.Header{"Authorization": {"Bearer " + token}})
+	assert.Equal(http.StatusOK, w.Code)
+	var resp struct {
+		Submissions []RoomSubmission `json:"submissions"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(resp.Submissions, 2)
+	assert.Equal("Schreibe 1.", resp.Submissions[0].Code)
+}
+
+func TestExerciseSubmissions(t *testing.T) {
+	assert := assert.New(t)
+	r := setupRooms(t)
+	room, token := createTestRoom(t, r)
+	other, _ := createTestRoom(t, r)
+
+	submission := ExerciseSubmission{ExerciseID: "hallo", StudentName: "Anna", Code: "Schreibe 1.", Passed: 1, Total: 2}
+	for i := range 3 {
+		submission.ID, submission.CreatedAt = uuid.NewString(), time.Now().UTC()
+		if i < 2 {
+			assert.NoError(storeExerciseSubmission(room.ID, 2, submission))
+		} else {
+			assert.Equal(errRoomFull, storeExerciseSubmission(room.ID, 2, submission), "max_room_submissions")
+		}
+	}
+	assert.NoError(storeExerciseSubmission(other.ID, 2, submission))
+
+	path := "/rooms/" + room.ID + "/exercises"
+	assert.Equal(http.StatusUnauthorized, request(r, http.MethodGet, path, nil, nil).Code)
+	w := request(r, http.MethodGet, path, nil, http.Header{"Authorization": {"Bearer " + token}})
+	assert.Equal(http.StatusOK, w.Code)
+	var resp struct {
+		Submissions []ExerciseSubmission `json:"submissions"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Submissions, 2)
+	assert.Equal("Anna", resp.Submissions[0].StudentName)
+	assert.Equal("hallo", resp.Submissions[0].ExerciseID)
+	assert.Equal("Schreibe 1.", resp.Submissions[0].Code)
+	assert.Equal(1, resp.Submissions[0].Passed)
+}
+
+func TestTeacherAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	r := setupRooms(t)
+	room, token := createTestRoom(t, r)
+	path := "/rooms/" + room.ID + "/submissions"
+
+	auth := http.Header{"Authorization": {"Bearer " + token}}
+
+	assert.Equal(http.StatusOK, request(r, http.MethodGet, path, nil, auth).Code)
+	assert.Equal(http.StatusUnauthorized, request(r, http.MethodGet, path, nil, nil).Code)
+	assert.Equal(http.StatusUnauthorized, request(r, http.MethodGet, path, nil, http.Header{"Authorization": {"Bearer falsch"}}).Code)
+	assert.Equal(http.StatusNotFound, request(r, http.MethodGet, "/rooms/unbekannt/submissions", nil, auth).Code)
+	// the token is not accepted in the url
+	assert.Equal(http.StatusUnauthorized, request(r, http.MethodGet, path+"?token="+token, nil, nil).Code)
+
+	// the view is the same page for everyone and never contains the token
+	w := request(r, http.MethodGet, "/rooms/"+room.ID+"/view", nil, auth)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("no-referrer", w.Header().Get("Referrer-Policy"))
+	assert.NotContains(w.Body.String(), token)
+	assert.NotContains(w.Body.String(), room.Name)
+}
+
+func TestRoomCreateRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	r := setupRooms(t)
+
+	for range 3 {
+		createTestRoom(t, r)
+	}
+	w := request(r, http.MethodPost, "/rooms", gin.H{"name": "7c"}, nil)
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+}
+
+func TestJoinCodeRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	r := setupRooms(t)
+	room, _ := createTestRoom(t, r)
+
+	wrong := gin.H{"joinCode": "FALSCH", "studentName": "Anna", "code": ""}
+	for range 3 {
+		assert.Equal(http.StatusNotFound, request(r, http.MethodPost, "/rooms/submit", wrong, nil).Code)
+	}
+	right := gin.H{"joinCode": room.JoinCode, "studentName": "Anna", "code": ""}
+	assert.Equal(http.StatusTooManyRequests, request(r, http.MethodPost, "/rooms/submit", right, nil).Code)
+}
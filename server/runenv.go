@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// the variable with a fixed seed for random numbers
+const seedEnv = "SPIELPLATZ_SEED"
+
+// the locale variables a run may set
+var localeEnvs = []string{
+	"LANG",
+	"LC_ALL",
+	"LC_COLLATE",
+	"LC_CTYPE",
+	"LC_MESSAGES",
+	"LC_MONETARY",
+	"LC_NUMERIC",
+	"LC_TIME",
+}
+
+var timezoneName = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// validates the environment of a run given as NAME=value
+// only locales from run_locales, timezones from zoneinfo_dir and a numeric seed are allowed
+func runEnv(vars []string) ([]string, error) {
+	if len(vars) > len(localeEnvs)+2 {
+		return nil, errors.New("Zu viele Umgebungsvariablen")
+	}
+	env := make([]string, 0, len(vars))
+	for _, v := range vars {
+		// the errors end up in the close message, which is limited to 123 bytes
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || len(v) > 64 {
+			return nil, errors.New("Ungültige Umgebungsvariable")
+		}
+		switch {
+		case slices.Contains(localeEnvs, name):
+			if !slices.Contains(viper.GetStringSlice("run_locales"), value) {
+				return nil, fmt.Errorf("Die Sprache %q wird nicht unterstützt", value)
+			}
+		case name == "TZ":
+			tz, err := posixTimezone(value)
+			if err != nil {
+				return nil, err
+			}
+			value = tz
+		case name == seedEnv:
+			if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("Ungültiger Startwert %q", value)
+			}
+		default:
+			return nil, fmt.Errorf("Die Umgebungsvariable %q ist nicht erlaubt", name)
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// converts a timezone like Europe/Berlin into its POSIX rule (e.g. CET-1CEST,M3.5.0,M10.5.0/3)
+// the program can not read the timezone files, so TZ has to contain the rule itself
+func posixTimezone(name string) (string, error) {
+	invalid := fmt.Errorf("Unbekannte Zeitzone %q", name)
+	if !timezoneName.MatchString(name) {
+		return "", invalid
+	}
+	data, err := os.ReadFile(filepath.Join(viper.GetString("zoneinfo_dir"), name))
+	if err != nil {
+		return "", invalid
+	}
+	// since version 2 a TZif file ends with the rule between two newlines
+	if !bytes.HasPrefix(data, []byte("TZif")) || len(data) < 5 || data[4] < '2' || !bytes.HasSuffix(data, []byte("\n")) {
+		return "", invalid
+	}
+	data = data[:len(data)-1]
+	rule := string(data[bytes.LastIndexByte(data, '\n')+1:])
+	if rule == "" || strings.ContainsFunc(rule, func(r rune) bool { return r < ' ' || r > '~' }) {
+		return "", invalid
+	}
+	return rule, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	wsrw "github.com/DDP-Projekt/Spielplatz/server/websocket_rw"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+// a running program that clients can reattach to or spectate
+type activeRun struct {
+	rw    *wsrw.WebsocketRW
+	token string        // secret of the run owner
+	done  chan struct{} // closed when the run ended
+
+	mu sync.Mutex
+	// issued spectate tokens and whether they see the input of the owner
+	spectate_tokens map[string]bool
+}
+
+var (
+	activeRunsMutex sync.Mutex
+	activeRuns      = map[string]*activeRun{}
+)
+
+func generateRunToken() (string, error) {
+	token_bytes := make([]byte, 32)
+	if _, err := rand.Read(token_bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token_bytes), nil
+}
+
+// registers the run of rw and returns the run id, the owner token
+// and a function that must be called when the run ended
+func registerRun(rw *wsrw.WebsocketRW) (string, string, func(), error) {
+	token, err := generateRunToken()
+	if err != nil {
+		return "", "", nil, err
+	}
+	id := uuid.NewString()
+	run := &activeRun{
+		rw:              rw,
+		token:           token,
+		done:            make(chan struct{}),
+		spectate_tokens: map[string]bool{},
+	}
+
+	activeRunsMutex.Lock()
+	activeRuns[id] = run
+	activeRunsMutex.Unlock()
+
+	return id, run.token, func() {
+		activeRunsMutex.Lock()
+		delete(activeRuns, id)
+		activeRunsMutex.Unlock()
+		close(run.done)
+	}, nil
+}
+
+func getActiveRun(id string) (*activeRun, bool) {
+	activeRunsMutex.Lock()
+	defer activeRunsMutex.Unlock()
+	run, ok := activeRuns[id]
+	return run, ok
+}
+
+// returns the run if token is the owner token
+func getOwnedRun(id, token string) (*activeRun, bool) {
+	run, ok := getActiveRun(id)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(run.token)) != 1 {
+		return nil, false
+	}
+	return run, true
+}
+
+// serves the /run/resume endpoint
+// the client reattaches to a running program and receives all output after seq
+func serve_resume_run(c *gin.Context) {
+	logger := getLogger(c)
+	// the run keeps the protocol it was started with
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, runUpgradeHeader(c.Request))
+	if err != nil {
+		logger.Error("failed to initialize websocket connection", "err", err.Error())
+		return
+	}
+	defer ws.Close()
+
+	run_id := c.Query("run_id")
+	logger = logger.With("run_id", run_id)
+	run, ok := getOwnedRun(run_id, c.Query("resume_token"))
+	if !ok {
+		logger.Warn("unknown run or invalid resume token")
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Unbekannter Lauf"))
+		return
+	}
+	seq, err := strconv.ParseUint(c.DefaultQuery("seq", "0"), 10, 64)
+	if err != nil {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, "invalid seq"))
+		return
+	}
+
+	if err := run.rw.Attach(ws, seq); err != nil {
+		logger.Warn("failed to reattach to run", "err", err)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Der Lauf ist nicht mehr verfügbar"))
+		return
+	}
+	logger.Info("client reattached to run", "seq", seq)
+	// the connection is used by the run until it ended
+	<-run.done
+}
+
+type SpectateTokenRequest struct {
+	RunID       string `json:"runId"`
+	ResumeToken string `json:"resumeToken"`
+	// whether spectators see the input of the run owner
+	EchoStdin bool `json:"echoStdin"`
+}
+
+// serves the /run/spectate_token endpoint
+// the run owner gets a read-only token for spectators
+func serve_spectate_token(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req SpectateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ungültige Anfrage"})
+		return
+	}
+	run, ok := getOwnedRun(req.RunID, req.ResumeToken)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unbekannter Lauf"})
+		return
+	}
+
+	token, err := generateRunToken()
+	if err != nil {
+		logger.Error("failed to generate spectate token", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Interner Fehler"})
+		return
+	}
+	run.mu.Lock()
+	run.spectate_tokens[token] = req.EchoStdin
+	run.mu.Unlock()
+
+	logger.Info("issued spectate token", "run_id", req.RunID, "echo_stdin", req.EchoStdin)
+	c.JSON(http.StatusOK, gin.H{"spectateToken": token})
+}
+
+// serves the /run/spectate endpoint
+// spectators receive the output of a running program but cannot send input
+func serve_spectate_run(c *gin.Context) {
+	logger := getLogger(c)
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Error("failed to initialize websocket connection", "err", err.Error())
+		return
+	}
+	defer ws.Close()
+
+	run_id := c.Query("run_id")
+	logger = logger.With("run_id", run_id)
+	run, ok := getActiveRun(run_id)
+	var echo_stdin bool
+	if ok {
+		run.mu.Lock()
+		echo_stdin, ok = run.spectate_tokens[c.Query("token")]
+		run.mu.Unlock()
+	}
+	if !ok {
+		logger.Warn("unknown run or invalid spectate token")
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Unbekannter Lauf"))
+		return
+	}
+
+	logger.Info("spectator connected")
+	if err := run.rw.Spectate(ws, echo_stdin, viper.GetInt("run_max_spectators"), viper.GetInt("run_spectator_send_buffer"), logger); err != nil {
+		logger.Warn("spectator rejected", "err", err)
+		if errors.Is(err, wsrw.ErrTooManySpectators) {
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Zu viele Zuschauer"))
+		} else {
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Der Lauf ist bereits beendet"))
+		}
+		return
+	}
+	logger.Info("spectator disconnected")
+}
+
+// reserves a run of the executable of token
+// if that is not possible, ws is closed with the reason
+func acquireExecutable(ws *websocket.Conn, rw *wsrw.WebsocketRW, token executables.TokenType, logger *slog.Logger) (string, func(), bool) {
+	exe_path, release, err := executables.AcquireRun(token, viper.GetInt("max_runs_per_token"), viper.GetInt("max_active_runs_per_token"))
+	if err == nil {
+		return exe_path, release, true
+	}
+	logger.Warn("cannot run executable", "err", err)
+	code, error_code, msg := websocket.CloseInvalidFramePayloadData, "invalid_token", "Ungültiger Token"
+	switch {
+	case errors.Is(err, executables.ErrTooManyRuns):
+		code, error_code, msg = websocket.ClosePolicyViolation, "too_many_runs", err.Error()
+	case errors.Is(err, executables.ErrTooManyActive):
+		code, error_code, msg = websocket.CloseTryAgainLater, "too_many_active", err.Error()
+	}
+	rejectRun(ws, rw, code, err.Error(), error_code, msg)
+	return "", nil, false
+}
+
+// closes the connection of a run that could not be started with reason
+// v2 clients get a typed error with error_code and msg before, rw may be nil
+func rejectRun(ws *websocket.Conn, rw *wsrw.WebsocketRW, code int, reason, error_code, msg string) {
+	if rw != nil {
+		rw.WriteError(error_code, msg)
+	}
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
+// reports if the client asked for version 2 of the run protocol
+// with the query parameter protocol=2 or the websocket subprotocol
+func runProtocolV2(c *gin.Context) bool {
+	return c.Query("protocol") == "2" || slices.Contains(websocket.Subprotocols(c.Request), wsrw.ProtocolV2)
+}
+
+// accepts the subprotocol of version 2 if the client asked for it
+func runUpgradeHeader(r *http.Request) http.Header {
+	if slices.Contains(websocket.Subprotocols(r), wsrw.ProtocolV2) {
+		return http.Header{"Sec-Websocket-Protocol": {wsrw.ProtocolV2}}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	procqueue "github.com/DDP-Projekt/Spielplatz/server/proc_queue"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// a priority class as configured in config.json
+type PriorityClassConfig struct {
+	Name string `mapstructure:"name"`
+	// clients that send this key are scheduled in this class
+	ApiKey                string `mapstructure:"api_key"`
+	ReservedProcesses     int    `mapstructure:"reserved_processes"`
+	MaxProcessesPerClient int    `mapstructure:"max_processes_per_client"`
+}
+
+var priorityClasses []PriorityClassConfig
+
+func initProcessQueue() error {
+	if err := viper.UnmarshalKey("priority_classes", &priorityClasses); err != nil {
+		return err
+	}
+
+	cfg := procqueue.Config{
+		Slots:              viper.GetInt("max_concurrent_processes"),
+		MaxQueued:          viper.GetInt("max_queued_processes"),
+		MaxPerClient:       viper.GetInt("max_processes_per_client"),
+		MaxQueuedPerClient: viper.GetInt("max_queued_per_client"),
+	}
+	for _, class := range priorityClasses {
+		if class.ApiKey == "" {
+			return errors.New("priority class " + class.Name + " has no api_key")
+		}
+		cfg.Classes = append(cfg.Classes, procqueue.Class{
+			Name:         class.Name,
+			Reserved:     class.ReservedProcesses,
+			MaxPerClient: class.MaxProcessesPerClient,
+		})
+	}
+	return kddp.InitializeProcessQueue(cfg)
+}
+
+// identifies the client of a request for the process queue
+// the api key is only read from the X-API-Key header, query parameters end up in logs
+func processClient(c *gin.Context) procqueue.Client {
+	client := procqueue.Client{ID: c.ClientIP()}
+
+	api_key := c.GetHeader("X-API-Key")
+	if api_key == "" {
+		return client
+	}
+
+	for _, class := range priorityClasses {
+		if subtle.ConstantTimeCompare([]byte(api_key), []byte(class.ApiKey)) == 1 {
+			client.Class = class.Name
+			return client
+		}
+	}
+	getLogger(c).Warn("unknown api key, using default priority class")
+	return client
+}
+
+// reports whether err means that the process could not be scheduled
+func isBusyErr(err error) bool {
+	return errors.Is(err, kddp.ErrServerBusy) || errors.Is(err, kddp.ErrTooManyProcesses)
+}
+
+// the user facing message for an error that satisfies isBusyErr
+func busyMessage(err error) string {
+	if errors.Is(err, kddp.ErrTooManyProcesses) {
+		return kddp.ErrTooManyProcesses.Error()
+	}
+	return kddp.ErrServerBusy.Error()
+}
+
+// rejects a request because it could not be scheduled
+func serveBusy(c *gin.Context, err error) {
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, kddp.ErrTooManyProcesses) {
+		status = http.StatusTooManyRequests
+	}
+	retry_after := int(viper.GetDuration("queue_retry_after").Seconds())
+	c.Header("Retry-After", strconv.Itoa(max(retry_after, 1)))
+	c.JSON(status, gin.H{"error": busyMessage(err)})
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,7 +16,9 @@ import (
 
 	"github.com/DDP-Projekt/DDPLS/ddpls"
 	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	"github.com/DDP-Projekt/Spielplatz/server/graphics"
 	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	"github.com/DDP-Projekt/Spielplatz/server/transcript"
 	wsrw "github.com/DDP-Projekt/Spielplatz/server/websocket_rw"
 	gin_pprof "github.com/gin-contrib/pprof"
 	"github.com/gin-contrib/requestid"
@@ -62,19 +65,95 @@ func getLogger(c *gin.Context) *slog.Logger {
 
 func setup_config() {
 	viper.SetDefault("exe_cache_duration", time.Second*60)
+	viper.SetDefault("exe_dir", "playground_executables")
+	viper.SetDefault("exe_dir_max_bytes", 1024*1024*1024)
+	viper.SetDefault("exe_dir_max_files", 1000)
+	viper.SetDefault("exe_janitor_interval", time.Second*5)
 	viper.SetDefault("run_timeout", time.Second*60)
+	viper.SetDefault("max_runs_per_token", 20)
+	viper.SetDefault("max_active_runs_per_token", 2)
+	viper.SetDefault("run_session_limit", time.Minute*30)
+	viper.SetDefault("run_idle_timeout", time.Minute*5)
+	viper.SetDefault("run_cpu_limit", time.Second*10)
+	viper.SetDefault("run_locales", []string{"C.UTF-8", "de_DE.UTF-8"})
+	viper.SetDefault("run_locale_dir", "/usr/lib/locale")
+	viper.SetDefault("zoneinfo_dir", "/usr/share/zoneinfo")
+	viper.SetDefault("graphics_max_commands", 100000)
+	viper.SetDefault("graphics_max_line_bytes", 1024)
+	viper.SetDefault("graphics_max_canvas_size", 2000)
+	viper.SetDefault("max_input_files", 10)
+	viper.SetDefault("max_input_files_bytes", 1024*1024)
+	viper.SetDefault("output_files_max_bytes", 1024*1024)
+	viper.SetDefault("output_files_max_count", 20)
+	viper.SetDefault("output_files_ttl", time.Minute*10)
+	viper.SetDefault("output_files_poll_interval", time.Millisecond*100)
 	viper.SetDefault("share_db_path", "./share_links.db")
+	viper.SetDefault("exercises_dir", "./exercises")
+	viper.SetDefault("room_default_duration", time.Hour*24*7)
+	viper.SetDefault("room_max_duration", time.Hour*24*30)
+	viper.SetDefault("room_cleanup_interval", time.Minute*10)
+	viper.SetDefault("max_room_submissions", 1000)
+	viper.SetDefault("max_room_submission_bytes", 256*1024)
+	viper.SetDefault("room_join_attempts", 20)
+	viper.SetDefault("room_join_window", time.Minute)
+	viper.SetDefault("rooms_per_client", 10)
+	viper.SetDefault("rooms_window", time.Hour)
+	viper.SetDefault("collab_max_sessions", 500)
+	viper.SetDefault("collab_max_participants", 30)
+	viper.SetDefault("collab_max_document_length", 256*1024)
+	viper.SetDefault("collab_max_history", 500)
+	viper.SetDefault("collab_idle_timeout", time.Minute*30)
+	viper.SetDefault("collab_send_buffer", 256)
+	viper.SetDefault("collab_sessions_per_client", 10)
+	viper.SetDefault("collab_sessions_window", time.Minute*30)
+	viper.SetDefault("broadcast_max_broadcasts", 100)
+	viper.SetDefault("broadcast_max_viewers", 500)
+	viper.SetDefault("broadcast_max_code_length", 256*1024)
+	viper.SetDefault("broadcast_max_output_bytes", 64*1024)
+	viper.SetDefault("broadcast_idle_timeout", time.Minute*30)
+	viper.SetDefault("broadcast_send_buffer", 512)
+	viper.SetDefault("run_resume_buffer_bytes", 256*1024)
+	viper.SetDefault("run_resume_grace", time.Second*30)
+	viper.SetDefault("run_max_spectators", 10)
+	viper.SetDefault("run_spectator_send_buffer", 256)
+	viper.SetDefault("gdb_path", "gdb")
+	viper.SetDefault("debug_compile_flags", []string{})
+	viper.SetDefault("debug_timeout", time.Minute*15)
+	viper.SetDefault("debug_max_message_bytes", 1024*1024)
+	viper.SetDefault("perf_path", "perf")
+	viper.SetDefault("profile_frequency", 999)
+	viper.SetDefault("profile_call_graph", "dwarf")
+	viper.SetDefault("profile_run_timeout", time.Second*10)
+	viper.SetDefault("profile_script_timeout", time.Second*30)
+	viper.SetDefault("profile_max_script_bytes", 64*1024*1024)
+	viper.SetDefault("profile_max_data_bytes", 256*1024*1024)
+	viper.SetDefault("input_wait_poll_interval", time.Millisecond*100)
+	viper.SetDefault("max_transcript_bytes", 256*1024)
+	viper.SetDefault("max_share_output_bytes", 64*1024)
+	viper.SetDefault("transcript_retention", time.Hour*24*7)
+	viper.SetDefault("transcript_cleanup_interval", time.Hour)
 	viper.SetDefault("port", "8080")
 	viper.SetDefault("memory_limit_bytes", 4*(2<<29)) // 4 GiB
 	viper.SetDefault("cpu_limit_percent", 50)
 	viper.SetDefault("max_concurrent_processes", 50)
-	viper.SetDefault("process_aquire_timeout", time.Second*3)
+	viper.SetDefault("max_queued_processes", 100)
+	viper.SetDefault("max_processes_per_client", 4)
+	viper.SetDefault("max_queued_per_client", 10)
+	viper.SetDefault("priority_classes", []any{})
+	viper.SetDefault("trusted_proxies", []string{})
+	viper.SetDefault("process_aquire_timeout", time.Minute*2)
+	viper.SetDefault("queue_retry_after", time.Second*10)
 	viper.SetDefault("useHTTPS", false)
 	viper.SetDefault("certPath", "")
 	viper.SetDefault("keyPath", "")
 	viper.SetDefault("pprof", false)
 	viper.SetDefault("log_level", "INFO")
 	viper.SetDefault("max_source_code_log_length", 100)
+	viper.SetDefault("max_test_cases", 20)
+	viper.SetDefault("max_test_output_bytes", 64*1024)
+	viper.SetDefault("max_benchmark_runs", 20)
+	viper.SetDefault("max_benchmark_total_runs", 50)
+	viper.SetDefault("benchmark_run_timeout", time.Second*10)
 
 	var level slog.Level
 	if err := level.UnmarshalText(
@@ -111,17 +190,41 @@ func main() {
 	setup_config()
 	slog.Info("Starting server with DDPVERSION=" + DDPVERSION)
 
-	if err := kddp.InitializeSemaphore(viper.GetInt64("max_concurrent_processes")); err != nil {
-		fatal("failed to initialize semaphore", "err", err)
+	if err := initProcessQueue(); err != nil {
+		fatal("failed to initialize process queue", "err", err)
 	}
 
+	if err := executables.Init(executables.Config{
+		Dir:             viper.GetString("exe_dir"),
+		MaxBytes:        viper.GetInt64("exe_dir_max_bytes"),
+		MaxFiles:        viper.GetInt("exe_dir_max_files"),
+		JanitorInterval: viper.GetDuration("exe_janitor_interval"),
+	}); err != nil {
+		fatal("failed to initialize executables directory", "err", err)
+	}
 	initCompression()
 	if err := initShareLinksStorage(viper.GetString("share_db_path")); err != nil {
 		fatal("failed to initialize share links database", "err", err)
 	}
 	defer closeShareLinksStorage()
+	if err := initExercises(viper.GetString("exercises_dir")); err != nil {
+		fatal("failed to load exercises", "err", err)
+	}
+	if err := initRooms(); err != nil {
+		fatal("failed to initialize rooms", "err", err)
+	}
+	if err := initTranscripts(); err != nil {
+		fatal("failed to initialize transcripts", "err", err)
+	}
+	initCollab()
+	initBroadcasts()
 
 	r := gin.New()
+	// X-Forwarded-For is only used from these proxies, otherwise every client could choose its own ip
+	// for the limits per client
+	if err := r.SetTrustedProxies(viper.GetStringSlice("trusted_proxies")); err != nil {
+		fatal("invalid trusted_proxies", "err", err)
+	}
 	r.Use(
 		gin.Recovery(),
 		requestid.New(),
@@ -139,6 +242,7 @@ func main() {
 	// compression endpoints
 	api.POST("/create_share_code", serve_create_share_code)
 	api.GET("/get_share_data", serve_get_share_data)
+	api.GET("/transcripts/:id", serve_get_transcript)
 
 	// websocket endpoint to connect to the language server
 	lslogging.Configure(1, nil)
@@ -147,6 +251,41 @@ func main() {
 	// endpoint to compile a ddp program
 	api.POST("/compile", serve_compile)
 	api.GET("/run", serve_run)
+	api.GET("/run/resume", serve_resume_run)
+	api.POST("/run/spectate_token", serve_spectate_token)
+	api.GET("/run/spectate", serve_spectate_run)
+	api.GET("/run/files/:id/:name", serve_output_file)
+	// websocket endpoint to debug a program compiled with debug info
+	api.GET("/debug", serve_debug)
+	// endpoint to compile a ddp program and measure where it spends its time
+	api.POST("/profile", serve_profile)
+	// endpoint to compile a ddp program and check it against test cases
+	api.POST("/test", serve_test)
+	// endpoint to compile a ddp program and measure its run time
+	api.POST("/benchmark", serve_benchmark)
+
+	// exercise endpoints
+	api.GET("/exercises", serve_list_exercises)
+	api.GET("/exercises/:id", serve_get_exercise)
+	api.POST("/exercises/:id/submit", serve_submit_exercise)
+
+	// classroom endpoints
+	api.POST("/rooms", serve_create_room)
+	api.POST("/rooms/submit", serve_submit_to_room)
+	api.GET("/rooms/:id/submissions", serve_room_submissions)
+	api.GET("/rooms/:id/exercises", serve_room_exercise_submissions)
+	api.GET("/rooms/:id/view", serve_room_view)
+	api.GET("/rooms/:id/export", serve_room_export)
+
+	// collaborative editing endpoints
+	api.POST("/collab", serve_create_collab_session)
+	api.GET("/collab/:id", serve_collab)
+
+	// broadcast endpoints
+	api.POST("/broadcast", serve_create_broadcast)
+	api.GET("/broadcast/:id/present", serve_present_broadcast)
+	api.GET("/broadcast/:id/watch", serve_watch_broadcast)
+	api.GET("/broadcast/:id/fork", serve_fork_broadcast)
 
 	api.GET("/health", serve_health)
 	api.HEAD("/health", serve_health)
@@ -215,6 +354,10 @@ func serve_compile(c *gin.Context) {
 	logger := getLogger(c)
 	type CompileRequest struct {
 		Src string `json:"src"`
+		// compile with debug info for /debug
+		Debug bool `json:"debug"`
+		// read-only files the program can open by name, e.g. {"daten.txt": "..."}
+		Files map[string]string `json:"files"`
 	}
 
 	logger.Info("got compilation request")
@@ -229,54 +372,113 @@ func serve_compile(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateInputFiles(req.Files); err != nil {
+		logger.Warn("invalid input files", "err", err)
+		executables.Delete(token)
+		status := http.StatusBadRequest
+		if errors.Is(err, errInputFilesTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
 	src_code := bytes.NewBufferString(req.Src)
 	logger.Info("compiling the program", "source-code", truncSourceString(req.Src, viper.GetInt("max_source_code_log_length")))
 	// compile the program
-	result, exe_path, err := kddp.CompileDDPProgram(src_code, token, exe_path, logger)
+	compile := kddp.CompileDDPProgram[executables.TokenType]
+	if req.Debug {
+		compile = kddp.CompileDebugProgram[executables.TokenType]
+	}
+	result, exe_path, err := compile(c.Request.Context(), processClient(c), src_code, token, exe_path, logger)
+	if isBusyErr(err) {
+		logger.Warn("no process slot for compilation", "err", err)
+		executables.Delete(token)
+		serveBusy(c, err)
+		return
+	}
 	if err != nil {
 		logger.Error("compiling program", "err", err)
 		executables.Delete(token)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	executables.Set(token, exe_path)
-
-	logger.Info("compilation finished")
-	// delete the executable after 3 minutes
-	go func() {
-		dur := viper.GetDuration("exe_cache_duration")
-		time.Sleep(dur)
-		if _, ok := executables.Get(token); ok {
-			logger.Info("executable was unused for cache  duration, deleting it",
-				"exe_path", exe_path,
-				"cache_curation", dur,
-				"token", token,
-			)
+	if len(req.Files) > 0 && result.Error == nil {
+		if err := writeInputFiles(exe_path, req.Files); err != nil {
+			logger.Error("failed to write input files", "err", err)
 			executables.RemoveExecutableFile(token, exe_path)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// the executable can be run until the cache duration expired
+	executables.Set(token, exe_path, viper.GetDuration("exe_cache_duration"), func() {
+		if req.Debug {
+			removeDebugSource(exe_path, logger)
 		}
-	}()
+		removeInputFiles(exe_path, logger)
+	})
+
+	logger.Info("compilation finished")
 	// send the result to the client
 	c.JSON(http.StatusOK, result)
 }
 
+// compiles src for a single request, the returned cleanup function deletes the executable
+// if the program did not compile the compilation result has Error set
+// if an error response was already written ok is false
+func compileOnce(c *gin.Context, logger *slog.Logger, src string) (exe_path string, compilation kddp.ProgramResult[executables.TokenType], cleanup func(), ok bool) {
+	token, exe_path := executables.GenerateExeToken()
+	logger = logger.With("token", token)
+	logger.Info("compiling the program", "source-code", truncSourceString(src, viper.GetInt("max_source_code_log_length")))
+
+	compilation, exe_path, err := kddp.CompileDDPProgram(c.Request.Context(), processClient(c), bytes.NewBufferString(src), token, exe_path, logger)
+	if isBusyErr(err) {
+		logger.Warn("no process slot for compilation", "err", err)
+		executables.Delete(token)
+		serveBusy(c, err)
+		return "", compilation, nil, false
+	}
+	if err != nil {
+		logger.Error("compiling program", "err", err)
+		executables.Delete(token)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", compilation, nil, false
+	}
+	if compilation.Error != nil {
+		executables.Delete(token)
+		return "", compilation, func() {}, true
+	}
+	// the executable is kept until the request is done and deleted afterwards
+	return exe_path, compilation, executables.SetRunning(token, exe_path, nil), true
+}
+
 // serves the /run endpoint
 func serve_run(c *gin.Context) {
 	logger := getLogger(c)
 	logger.Info("new run request")
+	if kddp.QueueFull() {
+		logger.Warn("process queue is full, rejecting run request")
+		serveBusy(c, kddp.ErrServerBusy)
+		return
+	}
 	// upgrade the connection to a websocket connection
-	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, runUpgradeHeader(c.Request))
 	if err != nil {
 		logger.Error("failed to initialize websocket connection", "err", err.Error())
 		return
 	}
 	defer ws.Close()
+	websocket_rw := wsrw.NewWebsocketRW(ws)
+	if runProtocolV2(c) {
+		websocket_rw.EnableV2()
+	}
 	// get the token from the query
 	token_str, ok := c.GetQuery("token")
 	if !ok {
 		logger.Warn("missing token in run request")
 		// send a close message to the client with error
-		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, "invalid token"))
+		rejectRun(ws, websocket_rw, websocket.CloseInvalidFramePayloadData, "invalid token", "invalid_token", "Ungültiger Token")
 		return
 	}
 	logger.Info("got run request token", "token", token_str)
@@ -286,37 +488,198 @@ func serve_run(c *gin.Context) {
 	if err != nil {
 		logger.Warn("invalid token")
 		// send a close message to the client with error
-		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, "invalid token"))
+		rejectRun(ws, websocket_rw, websocket.CloseInvalidFramePayloadData, "invalid token", "invalid_token", "Ungültiger Token")
 		return
 	}
 	logger = logger.With("token", token)
-	// get the executable path from the executables map
-	exe_path, ok := executables.Get(token)
+	// the executable stays until exe_cache_duration expired, so it can be run again
+	exe_path, release, ok := acquireExecutable(ws, websocket_rw, token, logger)
 	if !ok {
-		logger.Warn("token was invalid")
-		// send a close message to the client with error
-		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, "invalid token"))
 		return
 	}
+	defer release()
 	logger = logger.With("exe_path", exe_path)
 
 	args, _ := c.GetQueryArray("args")
-	websocket_rw := wsrw.NewWebsocketRW(ws)
-	// run the executable
-	defer executables.RemoveExecutableFile(token, exe_path)
+	// locale, timezone and seed of the program
+	env_vars, _ := c.GetQueryArray("env")
+	env, err := runEnv(env_vars)
+	if err != nil {
+		logger.Warn("invalid environment", "err", err)
+		rejectRun(ws, websocket_rw, websocket.CloseInvalidFramePayloadData, err.Error(), "invalid_env", err.Error())
+		return
+	}
+	input_dir := inputFiles(exe_path)
+
+	var stdout, stderr io.Writer = websocket_rw.StdoutWriter(), websocket_rw.StderrWriter()
+	// the presenter of a broadcast streams the output to the viewers
+	bc := runBroadcast(c, logger)
+	if bc != nil {
+		stdout = io.MultiWriter(bc.OutputWriter(false), stdout)
+		stderr = io.MultiWriter(bc.OutputWriter(true), stderr)
+	}
+	// the run id and owner token are used to reattach and to invite spectators
+	run_id, resume_token, unregister, err := registerRun(websocket_rw)
+	if err != nil {
+		logger.Error("failed to register run", "err", err)
+		rejectRun(ws, websocket_rw, websocket.CloseInternalServerErr, "internal error", "internal_error", "Interner Serverfehler")
+		return
+	}
+	defer unregister()
+	logger = logger.With("run_id", run_id)
+	// resumable runs survive a short disconnect of the client
+	// only v2 clients get the resume token, so only they can reattach
+	resumable := c.Query("resumable") == "true" && runProtocolV2(c)
+	if resumable {
+		websocket_rw.EnableResume(viper.GetInt("run_resume_buffer_bytes"), viper.GetDuration("run_resume_grace"))
+	}
+	// binary clients get the exact bytes of the output
+	if c.Query("binary") == "true" {
+		websocket_rw.EnableBinary()
+	}
+	if err := websocket_rw.WriteResumeInfo(run_id, resume_token); err != nil {
+		logger.Warn("failed to send run info", "err", err)
+	}
+	// the transcript of the run is stored when the client asks for it
+	var recorder *transcript.Recorder
+	if c.Query("record") == "true" {
+		recorder = websocket_rw.Record(viper.GetInt("max_transcript_bytes"))
+	}
+	// drawing commands are validated and sent as their own messages, errors go to stderr
+	var graphics_out io.Writer
+	if c.Query("graphics") == "true" {
+		graphics_out = graphics.NewWriter(graphics.Limits{
+			MaxCommands:   viper.GetInt("graphics_max_commands"),
+			MaxLineLength: viper.GetInt("graphics_max_line_bytes"),
+			MaxCanvasSize: viper.GetInt("graphics_max_canvas_size"),
+		}, websocket_rw.WriteGraphics, func(msg string) {
+			stderr.Write([]byte(msg))
+		})
+	}
+	// files written by the program are offered for download after the run
+	var scratch_dir string
+	output_max_bytes, output_max_files := viper.GetInt64("output_files_max_bytes"), viper.GetInt("output_files_max_count")
+	if c.Query("files") == "true" {
+		dir, input_size, input_count, err := createScratchDir(input_dir)
+		if err != nil {
+			logger.Error("failed to create scratch directory", "err", err)
+			rejectRun(ws, websocket_rw, websocket.CloseInternalServerErr, "internal error", "internal_error", "Interner Serverfehler")
+			return
+		}
+		// the copied input files do not count towards the quota
+		output_max_bytes += input_size
+		output_max_files += input_count
+		if err := executables.ReserveScratch(dir, output_max_bytes); err != nil {
+			logger.Warn("no space for the scratch directory", "err", err)
+			os.RemoveAll(dir)
+			rejectRun(ws, websocket_rw, websocket.CloseTryAgainLater, "server busy", "server_busy", err.Error())
+			return
+		}
+		scratch_dir = dir
+	}
+	// result.ExitCode < 0 means that the program did not finish normally
+	// v2 clients get error_code (if set) with reason as typed error
+	closeRun := func(code int, reason, error_code string, result kddp.RunResult, err error) {
+		if bc != nil {
+			bc.EndRun(reason)
+		}
+		// give a reconnecting client the chance to receive the end of the run
+		if resumable && !websocket_rw.WaitAttached(viper.GetDuration("run_resume_grace")) {
+			logger.Info("client did not reattach before the run ended")
+		}
+		// only runs that were started have a runtime
+		if result.WallTime > 0 {
+			if err := websocket_rw.WriteEnd(wsrw.RunEnd{
+				ExitCode: result.ExitCode,
+				Signal:   result.Signal,
+				Reason:   kddp.LimitReason(err),
+				WallTime: result.WallTime,
+				CPUTime:  result.CPUTime,
+				MaxRSS:   result.MaxRSS,
+			}); err != nil {
+				logger.Warn("failed to send end of run", "err", err)
+			}
+		}
+		if error_code != "" {
+			if err := websocket_rw.WriteError(error_code, reason); err != nil {
+				logger.Warn("failed to send error", "err", err)
+			}
+		}
+		if recorder != nil {
+			if id, err := storeTranscript(recorder.Finish(result.ExitCode, reason)); err != nil {
+				logger.Error("failed to store transcript", "err", err)
+			} else if err := websocket_rw.WriteTranscriptID(id); err != nil {
+				logger.Warn("failed to send transcript id", "err", err)
+			}
+		}
+		if scratch_dir != "" {
+			if errors.Is(err, kddp.ErrOutputQuota) {
+				executables.RemoveScratch(scratch_dir)
+			} else if files := publishOutputFiles(scratch_dir, logger); files != nil {
+				if err := websocket_rw.WriteOutputFiles(files); err != nil {
+					logger.Warn("failed to send output files", "err", err)
+				}
+			}
+		}
+		websocket_rw.Close()
+		websocket_rw.WriteClose(code, reason)
+	}
 
-	logger.Info("running executable", "args", args)
-	exitStatus, err := kddp.RunExecutable(exe_path, websocket_rw, websocket_rw.StdoutWriter(), websocket_rw.StderrWriter(), args, logger)
+	// the viewers only see runs that got past the setup, closeRun ends them
+	if bc != nil {
+		bc.StartRun()
+	}
+	logger.Info("running executable", "args", args, "env", env)
+	// a client that is gone does not keep its place in the queue or its process
+	run_ctx, cancel_run := websocket_rw.Context(context.Background())
+	defer cancel_run()
+	result, err := kddp.RunExecutable(run_ctx, exe_path, websocket_rw, stdout, stderr, kddp.RunOptions{
+		Args:   args,
+		Client: processClient(c),
+		OnQueuePosition: func(pos int) {
+			logger.Debug("run is queued", "position", pos)
+			if err := websocket_rw.WriteQueuePosition(pos); err != nil {
+				logger.Warn("failed to send queue position", "err", err)
+			}
+		},
+		OnStart:        websocket_rw.Start,
+		Graphics:       graphics_out,
+		InputDir:       input_dir,
+		OutputDir:      scratch_dir,
+		OutputMaxBytes: output_max_bytes,
+		OutputMaxFiles: output_max_files,
+		Interactive:    true,
+		Env:            env,
+		OnInputWait: func(waiting bool) {
+			logger.Debug("input wait changed", "waiting", waiting)
+			if err := websocket_rw.WriteInputWait(waiting); err != nil {
+				logger.Warn("failed to send input wait", "err", err)
+			}
+		},
+	}, logger)
+	if errors.Is(context.Cause(run_ctx), wsrw.ErrDisconnected) {
+		logger.Info("client disconnected, run was cancelled", "err", err)
+		closeRun(websocket.CloseGoingAway, "client disconnected", "", result, err)
+		return
+	}
+	if isBusyErr(err) {
+		logger.Warn("no process slot for run", "err", err)
+		closeRun(websocket.CloseTryAgainLater, busyMessage(err), "server_busy", result, err)
+		return
+	}
+	if kddp.LimitReason(err) != "" {
+		logger.Info("run was stopped by a limit", "err", err)
+		closeRun(websocket.ClosePolicyViolation, err.Error(), "", result, err)
+		return
+	}
 	if err != nil {
 		logger.Error("failed to run executable", "err", err)
-		websocket_rw.Close()
 		// report error to client
-		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+		closeRun(websocket.CloseInternalServerErr, err.Error(), "run_failed", result, err)
 		return
 	}
 	logger.Info("executable ran successfully")
-	websocket_rw.Close()
-	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("Das Programm wurde mit Code %d beendet", exitStatus)))
+	closeRun(websocket.CloseNormalClosure, fmt.Sprintf("Das Programm wurde mit Code %d beendet", result.ExitCode), "", result, nil)
 }
 
 func truncSourceString(s string, max_len int) string {
@@ -1,11 +1,48 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
+// a router with the logger middleware of main
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("logger", slog.Default()) })
+	return r
+}
+
+// sends body as json to r
+func request(r *gin.Engine, method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// sets a config value for the test and restores the old value afterwards
+func setConfig(t *testing.T, key string, value any) {
+	old := viper.Get(key)
+	viper.Set(key, value)
+	t.Cleanup(func() { viper.Set(key, old) })
+}
+
 func TestTruncateSource(t *testing.T) {
 	assert := assert.New(t)
 
@@ -20,3 +57,64 @@ func TestTruncateSource(t *testing.T) {
 	assert.Equal("...Bind...",
 		truncSourceString("Binde \"Duden/Ausgabe\" ein.Binde \"Duden/Eingabe\" ein.test", 4))
 }
+
+func TestValidateInputFiles(t *testing.T) {
+	assert := assert.New(t)
+	setConfig(t, "max_input_files", 2)
+	setConfig(t, "max_input_files_bytes", 10)
+
+	assert.NoError(validateInputFiles(nil))
+	assert.NoError(validateInputFiles(map[string]string{"daten.txt": "1 2 3", "leer": ""}))
+	assert.Error(validateInputFiles(map[string]string{"a": "", "b": "", "c": ""}))
+	assert.ErrorIs(validateInputFiles(map[string]string{"a": strings.Repeat("x", 11)}), errInputFilesTooLarge)
+	for _, name := range []string{"", ".", "..", "../a", "a/b", "a\\b", "a\x00"} {
+		assert.Error(validateInputFiles(map[string]string{name: ""}), name)
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	assert := assert.New(t)
+	zoneinfo := t.TempDir()
+	setConfig(t, "zoneinfo_dir", zoneinfo)
+	setConfig(t, "run_locales", []string{"de_DE.UTF-8"})
+	assert.NoError(os.Mkdir(filepath.Join(zoneinfo, "Europe"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(zoneinfo, "Europe", "Berlin"), []byte("TZif2...\nCET-1CEST,M3.5.0,M10.5.0/3\n"), 0o644))
+	assert.NoError(os.WriteFile(filepath.Join(zoneinfo, "Alt"), []byte("TZif\x00...\n"), 0o644))
+
+	env, err := runEnv([]string{"LANG=de_DE.UTF-8", "TZ=Europe/Berlin", "SPIELPLATZ_SEED=42"})
+	assert.NoError(err)
+	assert.Equal([]string{"LANG=de_DE.UTF-8", "TZ=CET-1CEST,M3.5.0,M10.5.0/3", "SPIELPLATZ_SEED=42"}, env)
+
+	for _, v := range []string{"LANG=en_US.UTF-8", "TZ=Europe/Paris", "TZ=../Europe/Berlin", "TZ=/etc/passwd", "TZ=Alt", "SPIELPLATZ_SEED=-1", "PATH=/bin", "LD_PRELOAD=x.so", "LANG"} {
+		_, err := runEnv([]string{v})
+		assert.Error(err, v)
+	}
+}
+
+func TestProcessClient(t *testing.T) {
+	assert := assert.New(t)
+	old_classes := priorityClasses
+	priorityClasses = []PriorityClassConfig{{Name: "workshop", ApiKey: "geheim"}}
+	t.Cleanup(func() { priorityClasses = old_classes })
+
+	engine := gin.New()
+	assert.NoError(engine.SetTrustedProxies(nil))
+	client := func(header http.Header, query string) (string, string) {
+		c := gin.CreateTestContextOnly(httptest.NewRecorder(), engine)
+		c.Request = httptest.NewRequest(http.MethodGet, "/run"+query, nil)
+		c.Request.RemoteAddr = "192.0.2.1:1234"
+		c.Request.Header = header
+		client := processClient(c)
+		return client.ID, client.Class
+	}
+
+	id, class := client(http.Header{"X-Api-Key": {"geheim"}}, "")
+	assert.Equal("192.0.2.1", id)
+	assert.Equal("workshop", class)
+	// the key is not accepted as query parameter
+	_, class = client(http.Header{}, "?api_key=geheim")
+	assert.Equal("", class)
+	// X-Forwarded-For is ignored without trusted proxies
+	id, _ = client(http.Header{"X-Forwarded-For": {"198.51.100.7"}}, "")
+	assert.Equal("192.0.2.1", id)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
 )
 
 var zstdEncoder *zstd.Encoder
@@ -21,6 +23,22 @@ type ShareLink struct {
 	UUID           string
 	CompressedCode []byte
 	CreatedAt      time.Time
+	TranscriptUUID sql.NullString
+	// nil if no run output was shared
+	CompressedOutput []byte
+}
+
+// the output of a run as the author of a share link saw it
+type SharedOutput struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode *int   `json:"exitCode"` // null if the run did not finish normally
+}
+
+// run data that is optionally shared together with the code
+type SharedRun struct {
+	TranscriptID string        `json:"transcriptId"`
+	Output       *SharedOutput `json:"output"`
 }
 
 const createShareLinksTableSQL = `
@@ -72,7 +90,35 @@ func initShareLinksStorage(path string) error {
 	}
 
 	shareLinksDB = db
-	return nil
+	if err := addColumnIfMissing("share_links", "transcript_uuid", "TEXT"); err != nil {
+		return err
+	}
+	return addColumnIfMissing("share_links", "compressed_output", "BLOB")
+}
+
+// adds a column to an existing table of the share links database
+// used to migrate databases created by older versions
+func addColumnIfMissing(table, column, definition string) error {
+	rows, err := shareLinksDB.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = shareLinksDB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 func closeShareLinksStorage() {
@@ -83,15 +129,17 @@ func closeShareLinksStorage() {
 	}
 }
 
-func storeShareData(id string, compressedCode []byte) error {
+func storeShareData(id string, compressedCode []byte, transcriptID sql.NullString, compressedOutput []byte) error {
 	if shareLinksDB == nil {
 		return fmt.Errorf("share links database is not initialized")
 	}
 
 	_, err := shareLinksDB.Exec(
-		"INSERT INTO share_links (uuid, compressed_code) VALUES (?, ?)",
+		"INSERT INTO share_links (uuid, compressed_code, transcript_uuid, compressed_output) VALUES (?, ?, ?, ?)",
 		id,
 		compressedCode,
+		transcriptID,
+		compressedOutput,
 	)
 	return err
 }
@@ -103,9 +151,9 @@ func getShareData(id string) (ShareLink, error) {
 
 	var link ShareLink
 	err := shareLinksDB.QueryRow(
-		"SELECT uuid, compressed_code, created_at FROM share_links WHERE uuid = ?",
+		"SELECT uuid, compressed_code, created_at, transcript_uuid, compressed_output FROM share_links WHERE uuid = ?",
 		id,
-	).Scan(&link.UUID, &link.CompressedCode, &link.CreatedAt)
+	).Scan(&link.UUID, &link.CompressedCode, &link.CreatedAt, &link.TranscriptUUID, &link.CompressedOutput)
 	if err != nil {
 		return ShareLink{}, err
 	}
@@ -113,8 +161,27 @@ func getShareData(id string) (ShareLink, error) {
 	return link, nil
 }
 
+// compresses and stores code and the optional run data and returns the new share code
+func createShareLink(code string, run SharedRun) (string, error) {
+	encoded := zstdEncoder.EncodeAll([]byte(code), nil)
+	var output []byte
+	if run.Output != nil {
+		data, err := json.Marshal(run.Output)
+		if err != nil {
+			return "", err
+		}
+		output = zstdEncoder.EncodeAll(data, nil)
+	}
+	id := uuid.NewString()
+	if err := storeShareData(id, encoded, sql.NullString{String: run.TranscriptID, Valid: run.TranscriptID != ""}, output); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
 type CreateShareCodeRequest struct {
 	Code string `json:"code"`
+	SharedRun
 }
 
 func serve_create_share_code(c *gin.Context) {
@@ -127,9 +194,26 @@ func serve_create_share_code(c *gin.Context) {
 		return
 	}
 
-	encoded := zstdEncoder.EncodeAll([]byte(req.Code), nil)
-	id := uuid.NewString()
-	if err := storeShareData(id, encoded); err != nil {
+	if req.TranscriptID != "" {
+		exists, err := transcriptExists(req.TranscriptID)
+		if err != nil {
+			logger.Error("failed to look up transcript", "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate share code"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown transcript"})
+			return
+		}
+	}
+
+	if req.Output != nil && len(req.Output.Stdout)+len(req.Output.Stderr) > viper.GetInt("max_share_output_bytes") {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "output too large"})
+		return
+	}
+
+	id, err := createShareLink(req.Code, req.SharedRun)
+	if err != nil {
 		logger.Error("failed to store share data", "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate share code"})
 		return
@@ -161,5 +245,21 @@ func serve_get_share_data(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"code": string(decompressed)})
+	response := gin.H{"code": string(decompressed)}
+	if link.TranscriptUUID.Valid {
+		response["transcriptId"] = link.TranscriptUUID.String
+	}
+	if link.CompressedOutput != nil {
+		data, err := zstdDecoder.DecodeAll(link.CompressedOutput, nil)
+		var output SharedOutput
+		if err == nil {
+			err = json.Unmarshal(data, &output)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load shared output"})
+			return
+		}
+		response["output"] = output
+	}
+	c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,153 @@
+/*
+package testrunner runs a compiled program against a list of test cases
+*/
+package testrunner
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	procqueue "github.com/DDP-Projekt/Spielplatz/server/proc_queue"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/viper"
+)
+
+// a single test case
+// if ExpectedStdout is nil the output is not checked,
+// if ExpectedExitCode is nil the program has to exit with code 0
+type Case struct {
+	Args             []string `json:"args"`
+	Stdin            string   `json:"stdin"`
+	ExpectedStdout   *string  `json:"expectedStdout"`
+	ExpectedExitCode *int     `json:"expectedExitCode"`
+	// timeout in milliseconds, 0 means run_timeout
+	TimeoutMs int64 `json:"timeoutMs"`
+}
+
+// the result of a single test case
+type Result struct {
+	Passed   bool    `json:"passed"`
+	ExitCode int     `json:"exitCode"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	Error    *string `json:"error"` // null if the program ran without error
+	// unified diff of the expected and actual stdout, empty if they are equal
+	Diff       string `json:"diff,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// runs every case against the executable at exe_path
+// every case waits in the process queue on its own
+func Run(ctx context.Context, exe_path string, cases []Case, client procqueue.Client, logger *slog.Logger) []Result {
+	results := make([]Result, 0, len(cases))
+	for i, c := range cases {
+		results = append(results, runCase(ctx, exe_path, c, client, logger.With("case", i)))
+	}
+	return results
+}
+
+// number of passed results
+func Passed(results []Result) int {
+	passed := 0
+	for _, r := range results {
+		if r.Passed {
+			passed++
+		}
+	}
+	return passed
+}
+
+func runCase(ctx context.Context, exe_path string, c Case, client procqueue.Client, logger *slog.Logger) Result {
+	max_output := viper.GetInt("max_test_output_bytes")
+	stdout := &limitedBuffer{limit: max_output}
+	stderr := &limitedBuffer{limit: max_output}
+
+	run, err := kddp.RunExecutable(ctx, exe_path, strings.NewReader(c.Stdin), stdout, stderr, kddp.RunOptions{
+		Args:    c.Args,
+		Client:  client,
+		Timeout: time.Duration(c.TimeoutMs) * time.Millisecond,
+	}, logger)
+
+	result := Result{
+		ExitCode:   run.ExitCode,
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		DurationMs: run.WallTime.Milliseconds(),
+	}
+	// a non-zero exit code is checked below and is not an error
+	err = kddp.IgnoreExitCode(run, err)
+	if err != nil {
+		logger.Info("test case failed to run", "err", err)
+		err_string := err.Error()
+		result.Error = &err_string
+		return result
+	}
+
+	expected_exit_code := 0
+	if c.ExpectedExitCode != nil {
+		expected_exit_code = *c.ExpectedExitCode
+	}
+	result.Passed = run.ExitCode == expected_exit_code
+
+	if c.ExpectedStdout != nil {
+		result.Diff = Diff(*c.ExpectedStdout, result.Stdout)
+		result.Passed = result.Passed && result.Diff == ""
+	}
+	if stdout.truncated {
+		result.Passed = false
+	}
+	return result
+}
+
+// returns a unified diff between expected and actual or "" if they are equal
+// line endings and trailing newlines are ignored
+func Diff(expected, actual string) string {
+	expected, actual = normalizeOutput(expected), normalizeOutput(actual)
+	if expected == actual {
+		return ""
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected),
+		B:        difflib.SplitLines(actual),
+		FromFile: "erwartet",
+		ToFile:   "tatsächlich",
+		Context:  3,
+	})
+	if err != nil || diff == "" {
+		// should not happen, but a failed test must never have an empty diff
+		return "- " + expected + "\n+ " + actual + "\n"
+	}
+	return diff
+}
+
+func normalizeOutput(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.TrimRight(s, "\n") + "\n"
+}
+
+// a buffer that discards everything after limit bytes
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buf.Len(); len(p) > remaining {
+		b.buf.Write(p[:max(remaining, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[Ausgabe gekürzt]"
+	}
+	return b.buf.String()
+}
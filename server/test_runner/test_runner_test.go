@@ -0,0 +1,34 @@
+package testrunner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", Diff("a\nb", "a\nb\n"))
+	assert.Equal("", Diff("a\r\nb\r\n", "a\nb"))
+
+	diff := Diff("a\nb\nc\n", "a\nx\nc\n")
+	assert.Contains(diff, "--- erwartet")
+	assert.Contains(diff, "+++ tatsächlich")
+	assert.Contains(diff, "-b\n")
+	assert.Contains(diff, "+x\n")
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &limitedBuffer{limit: 4}
+	n, err := b.Write([]byte("abc"))
+	assert.NoError(err)
+	assert.Equal(3, n)
+	n, err = b.Write([]byte("def"))
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.True(b.truncated)
+	assert.Equal("abcd\n[Ausgabe gekürzt]", b.String())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	executables "github.com/DDP-Projekt/Spielplatz/server/execs_manager"
+	"github.com/DDP-Projekt/Spielplatz/server/kddp"
+	testrunner "github.com/DDP-Projekt/Spielplatz/server/test_runner"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+type TestRequest struct {
+	Src   string            `json:"src"`
+	Cases []testrunner.Case `json:"cases"`
+}
+
+type TestResponse struct {
+	Compilation kddp.ProgramResult[executables.TokenType] `json:"compilation"`
+	Cases       []testrunner.Result                       `json:"cases"`
+	Passed      int                                       `json:"passed"`
+	Total       int                                       `json:"total"`
+}
+
+// serves the /test endpoint
+// compiles the program once and runs it against every test case
+func serve_test(c *gin.Context) {
+	logger := getLogger(c)
+
+	var req TestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Error("unmarshaling request", "err", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if max_cases := viper.GetInt("max_test_cases"); len(req.Cases) > max_cases {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Es sind höchstens %d Testfälle erlaubt", max_cases)})
+		return
+	}
+
+	results, compilation, ok := compileAndRunCases(c, logger, req.Src, req.Cases)
+	if !ok {
+		return
+	}
+	if compilation.Error != nil {
+		c.JSON(http.StatusOK, TestResponse{Compilation: compilation, Total: len(req.Cases)})
+		return
+	}
+
+	passed := testrunner.Passed(results)
+	logger.Info("test run finished", "passed", passed, "total", len(results))
+	c.JSON(http.StatusOK, TestResponse{
+		Compilation: compilation,
+		Cases:       results,
+		Passed:      passed,
+		Total:       len(results),
+	})
+}
+
+// compiles src once and runs it against every case
+// if the program did not compile the compilation result has Error set and no cases are run
+// if an error response was already written ok is false
+func compileAndRunCases(c *gin.Context, logger *slog.Logger, src string, cases []testrunner.Case) (results []testrunner.Result, compilation kddp.ProgramResult[executables.TokenType], ok bool) {
+	logger.Info("compiling program for test run", "cases", len(cases))
+	exe_path, compilation, cleanup, ok := compileOnce(c, logger, src)
+	if !ok || compilation.Error != nil {
+		return nil, compilation, ok
+	}
+	defer cleanup()
+
+	return testrunner.Run(c.Request.Context(), exe_path, cases, processClient(c), logger), compilation, true
+}
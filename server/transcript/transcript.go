@@ -0,0 +1,137 @@
+/*
+package transcript records the input and output of a run with timestamps
+*/
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Stream string
+
+const (
+	Stdin  Stream = "stdin"
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+type Event struct {
+	// seconds since the start of the recording
+	Time   float64 `json:"time"`
+	Stream Stream  `json:"stream"`
+	Data   string  `json:"data"`
+}
+
+// a recorded run
+type Transcript struct {
+	StartedAt time.Time `json:"startedAt"`
+	Events    []Event   `json:"events"`
+	ExitCode  *int      `json:"exitCode"` // null if the run did not finish normally
+	// the message the run ended with
+	EndReason string `json:"endReason"`
+	// set if events were dropped because the transcript became too large
+	Truncated bool `json:"truncated"`
+}
+
+// records a Transcript, safe for concurrent use
+type Recorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	size      int
+	max_size  int
+	recording Transcript
+}
+
+// creates a recorder that keeps at most max_size bytes of data
+func NewRecorder(max_size int) *Recorder {
+	now := time.Now()
+	return &Recorder{
+		start:     now,
+		max_size:  max_size,
+		recording: Transcript{StartedAt: now.UTC(), Events: []Event{}},
+	}
+}
+
+// resets the start time of the recording, e.g. when the process actually started
+func (r *Recorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = time.Now()
+	r.recording.StartedAt = r.start.UTC()
+}
+
+// adds an event with the current time
+func (r *Recorder) Record(stream Stream, data string) {
+	if data == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recording.Truncated {
+		return
+	}
+	if r.size+len(data) > r.max_size {
+		r.recording.Truncated = true
+		return
+	}
+	r.size += len(data)
+	r.recording.Events = append(r.recording.Events, Event{
+		Time:   time.Since(r.start).Seconds(),
+		Stream: stream,
+		Data:   data,
+	})
+}
+
+// records how the run ended and returns the finished transcript
+// exit_code < 0 means that the run did not finish normally
+func (r *Recorder) Finish(exit_code int, reason string) Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if exit_code >= 0 {
+		r.recording.ExitCode = &exit_code
+	}
+	r.recording.EndReason = reason
+	result := r.recording
+	result.Events = append([]Event(nil), r.recording.Events...)
+	return result
+}
+
+// encodes the transcript as asciicast v2 (https://docs.asciinema.org/manual/asciicast/v2/)
+// stdout and stderr are both written as output events
+func (t Transcript) Asciicast() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(map[string]any{
+		"version":   2,
+		"width":     80,
+		"height":    24,
+		"timestamp": t.StartedAt.Unix(),
+		"title":     "DDP Spielplatz",
+	}); err != nil {
+		return nil, err
+	}
+
+	// terminals need a carriage return to start a new line
+	crlf := strings.NewReplacer("\r\n", "\r\n", "\n", "\r\n")
+	last := 0.0
+	for _, e := range t.Events {
+		code := "o"
+		if e.Stream == Stdin {
+			code = "i"
+		}
+		if err := enc.Encode([]any{e.Time, code, crlf.Replace(e.Data)}); err != nil {
+			return nil, err
+		}
+		last = e.Time
+	}
+	if t.EndReason != "" {
+		if err := enc.Encode([]any{last, "o", "\r\n" + t.EndReason + "\r\n"}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package transcript
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRecorder(10)
+	r.Record(Stdout, "Hallo\n")
+	r.Record(Stdin, "")
+	r.Record(Stdin, "1\n")
+	r.Record(Stderr, "zu lang\n")
+	r.Record(Stdout, "x")
+
+	transcript := r.Finish(3, "Das Programm wurde mit Code 3 beendet")
+	assert.True(transcript.Truncated)
+	assert.Len(transcript.Events, 2)
+	assert.Equal(Stdout, transcript.Events[0].Stream)
+	assert.Equal(Stdin, transcript.Events[1].Stream)
+	assert.Equal(3, *transcript.ExitCode)
+
+	assert.Nil(NewRecorder(10).Finish(-1, "").ExitCode)
+}
+
+func TestAsciicast(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRecorder(100)
+	r.Record(Stdout, "a\nb\n")
+	r.Record(Stdin, "c\n")
+	cast, err := r.Finish(0, "Ende").Asciicast()
+	assert.NoError(err)
+
+	lines := strings.Split(strings.TrimSpace(string(cast)), "\n")
+	assert.Len(lines, 4)
+
+	var header map[string]any
+	assert.NoError(json.Unmarshal([]byte(lines[0]), &header))
+	assert.EqualValues(2, header["version"])
+
+	var event []any
+	assert.NoError(json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal("o", event[1])
+	assert.Equal("a\r\nb\r\n", event[2])
+	assert.NoError(json.Unmarshal([]byte(lines[2]), &event))
+	assert.Equal("i", event[1])
+}
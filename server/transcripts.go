@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/DDP-Projekt/Spielplatz/server/transcript"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+const createTranscriptsTableSQL = `
+CREATE TABLE IF NOT EXISTS transcripts (
+	uuid TEXT PRIMARY KEY,
+	compressed_data BLOB NOT NULL,
+	created_at DATETIME NOT NULL
+);
+`
+
+// creates the transcripts table and starts deleting old transcripts
+// must be called after initShareLinksStorage
+func initTranscripts() error {
+	if _, err := shareLinksDB.Exec(createTranscriptsTableSQL); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			deleteOldTranscripts()
+			time.Sleep(viper.GetDuration("transcript_cleanup_interval"))
+		}
+	}()
+	return nil
+}
+
+// deletes transcripts older than transcript_retention that are not part of a share link
+func deleteOldTranscripts() {
+	res, err := shareLinksDB.Exec(
+		"DELETE FROM transcripts WHERE created_at < ? AND uuid NOT IN (SELECT transcript_uuid FROM share_links WHERE transcript_uuid IS NOT NULL)",
+		time.Now().UTC().Add(-viper.GetDuration("transcript_retention")),
+	)
+	if err != nil {
+		slog.Warn("failed to delete old transcripts", "err", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("deleted old transcripts", "count", n)
+	}
+}
+
+// compresses and stores the transcript and returns its id
+func storeTranscript(t transcript.Transcript) (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	_, err = shareLinksDB.Exec(
+		"INSERT INTO transcripts (uuid, compressed_data, created_at) VALUES (?, ?, ?)",
+		id, zstdEncoder.EncodeAll(data, nil), time.Now().UTC(),
+	)
+	return id, err
+}
+
+func getTranscript(id string) (transcript.Transcript, error) {
+	var compressed []byte
+	if err := shareLinksDB.QueryRow("SELECT compressed_data FROM transcripts WHERE uuid = ?", id).Scan(&compressed); err != nil {
+		return transcript.Transcript{}, err
+	}
+	data, err := zstdDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return transcript.Transcript{}, err
+	}
+	var t transcript.Transcript
+	err = json.Unmarshal(data, &t)
+	return t, err
+}
+
+func transcriptExists(id string) (bool, error) {
+	var n int
+	err := shareLinksDB.QueryRow("SELECT COUNT(*) FROM transcripts WHERE uuid = ?", id).Scan(&n)
+	return n > 0, err
+}
+
+// serves a stored transcript as json or, with format=asciicast, as asciicast v2 file
+func serve_get_transcript(c *gin.Context) {
+	logger := getLogger(c)
+
+	t, err := getTranscript(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unbekanntes Transkript"})
+		return
+	}
+	if err != nil {
+		logger.Error("failed to load transcript", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transkript konnte nicht geladen werden"})
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, t)
+	case "asciicast":
+		cast, err := t.Asciicast()
+		if err != nil {
+			logger.Error("failed to encode asciicast", "err", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transkript konnte nicht umgewandelt werden"})
+			return
+		}
+		if c.Query("download") == "true" {
+			c.Header("Content-Disposition", `attachment; filename="`+c.Param("id")+`.cast"`)
+		}
+		c.Data(http.StatusOK, "application/x-asciicast", cast)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unbekanntes Format"})
+	}
+}
@@ -0,0 +1,36 @@
+package websocket_rw
+
+// keeps the most recent output messages up to a total size in bytes
+// so that they can be sent again after a client reattached
+type history struct {
+	msgs     []ws_msg
+	size     int
+	max_size int
+}
+
+func newHistory(max_size int) *history {
+	return &history{max_size: max_size}
+}
+
+func (h *history) add(msg ws_msg) {
+	h.msgs = append(h.msgs, msg)
+	h.size += msg.size()
+	drop := 0
+	for h.size > h.max_size && drop < len(h.msgs) {
+		h.size -= h.msgs[drop].size()
+		drop++
+	}
+	if drop > 0 {
+		h.msgs = append(h.msgs[:0:0], h.msgs[drop:]...)
+	}
+}
+
+// returns all kept messages with a sequence number greater than seq
+func (h *history) since(seq uint64) []ws_msg {
+	for i, msg := range h.msgs {
+		if msg.Seq > seq {
+			return h.msgs[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package websocket_rw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryDropsOldest(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newHistory(5)
+	h.add(ws_msg{Msg: "ab", Seq: 1})
+	h.add(ws_msg{Msg: "cd", Seq: 2})
+	h.add(ws_msg{Msg: "ef", Seq: 3})
+
+	assert.Equal(4, h.size)
+	assert.Equal([]ws_msg{{Msg: "cd", Seq: 2}, {Msg: "ef", Seq: 3}}, h.since(0))
+	assert.Equal([]ws_msg{{Msg: "ef", Seq: 3}}, h.since(2))
+	assert.Empty(h.since(3))
+}
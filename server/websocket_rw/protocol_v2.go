@@ -0,0 +1,144 @@
+package websocket_rw
+
+import (
+	"time"
+
+	"github.com/DDP-Projekt/Spielplatz/server/graphics"
+)
+
+// the websocket subprotocol of version 2 of the run protocol
+const ProtocolV2 = "spielplatz.v2"
+
+// every message of protocol v2 has a type:
+// started, stdout, stderr, graphics, stdin-ack, input-wait, queue, resume, transcript, output-files, exit and error
+// v1 clients only get the output messages ({"msg", "isStderr"}), drawing commands they asked for and the close frame
+
+// switches to protocol v2, must be called before anything is sent
+func (rw *WebsocketRW) EnableV2() {
+	rw.v2 = true
+}
+
+type started_msg_v2 struct {
+	Type string `json:"type"`
+}
+
+type output_msg_v2 struct {
+	Type     string            `json:"type"`
+	Data     string            `json:"data,omitempty"`
+	Graphics *graphics.Command `json:"graphics,omitempty"`
+	Seq      uint64            `json:"seq"`
+	TimeMs   float64           `json:"timeMs"`
+}
+
+type stdin_ack_msg_v2 struct {
+	Type   string  `json:"type"`
+	Bytes  int     `json:"bytes"`
+	TimeMs float64 `json:"timeMs"`
+}
+
+type input_wait_msg_v2 struct {
+	Type    string  `json:"type"`
+	Waiting bool    `json:"waiting"`
+	TimeMs  float64 `json:"timeMs"`
+}
+
+type queue_msg_v2 struct {
+	Type     string `json:"type"`
+	Position int    `json:"position"`
+}
+
+type resume_msg_v2 struct {
+	Type        string `json:"type"`
+	RunID       string `json:"runId"`
+	ResumeToken string `json:"resumeToken"`
+}
+
+type transcript_msg_v2 struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type output_files_msg_v2 struct {
+	Type  string       `json:"type"`
+	Files []OutputFile `json:"files"`
+}
+
+type rusage_v2 struct {
+	WallTimeMs  float64 `json:"wallTimeMs"`
+	CPUTimeMs   float64 `json:"cpuTimeMs"`
+	MaxRSSBytes int64   `json:"maxRssBytes"`
+}
+
+type exit_msg_v2 struct {
+	Type   string    `json:"type"`
+	Code   int       `json:"code"`
+	Signal string    `json:"signal,omitempty"`
+	Reason string    `json:"reason"` // exited, signal or the limit that ended the run
+	Rusage rusage_v2 `json:"rusage"`
+}
+
+type error_msg_v2 struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`    // machine readable, e.g. invalid_token
+	Message string `json:"message"` // german text for the user
+}
+
+// how a run ended
+type RunEnd struct {
+	ExitCode int
+	Signal   string // the signal that killed the program, "" if it exited
+	Reason   string // set if a limit ended the run
+	WallTime time.Duration
+	CPUTime  time.Duration
+	MaxRSS   int64 // in bytes, 0 if unknown
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// the output message in the protocol of rw
+func (rw *WebsocketRW) outputMsg(msg ws_msg) any {
+	if !rw.v2 {
+		return msg
+	}
+	typ := "stdout"
+	switch {
+	case msg.Graphics != nil:
+		typ = "graphics"
+	case msg.IsStderr:
+		typ = "stderr"
+	}
+	return output_msg_v2{Type: typ, Data: msg.Msg, Graphics: msg.Graphics, Seq: msg.Seq, TimeMs: msg.TimeMs}
+}
+
+func (end RunEnd) v2() exit_msg_v2 {
+	reason := end.Reason
+	switch {
+	case reason != "":
+	case end.Signal != "":
+		reason = "signal"
+	default:
+		reason = "exited"
+	}
+	return exit_msg_v2{
+		Type:   "exit",
+		Code:   end.ExitCode,
+		Signal: end.Signal,
+		Reason: reason,
+		Rusage: rusage_v2{
+			WallTimeMs:  milliseconds(end.WallTime),
+			CPUTimeMs:   milliseconds(end.CPUTime),
+			MaxRSSBytes: end.MaxRSS,
+		},
+	}
+}
+
+// informs a v2 client about an error, v1 clients only get the reason of the close frame
+func (rw *WebsocketRW) WriteError(code, message string) error {
+	if !rw.v2 {
+		return nil
+	}
+	_, err := rw.writeMsg(error_msg_v2{Type: "error", Code: code, Message: message}, 0)
+	return err
+}
@@ -0,0 +1,61 @@
+package websocket_rw
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	fanout "github.com/DDP-Projekt/Spielplatz/server/fan_out"
+	"github.com/DDP-Projekt/Spielplatz/server/graphics"
+	"github.com/gorilla/websocket"
+)
+
+var ErrTooManySpectators = errors.New("too many spectators")
+
+// read-only connections that receive the output of the run,
+// the data of a spectator is whether it wants the input of the run owner
+type spectators = fanout.Group[bool]
+
+type spectator_msg struct {
+	Msg      string            `json:"msg"`
+	IsStderr bool              `json:"isStderr"`
+	IsStdin  bool              `json:"isStdin"`
+	TimeMs   float64           `json:"timeMs"`
+	Graphics *graphics.Command `json:"-"` // sent as graphics_msg
+}
+
+func (msg spectator_msg) MarshalJSON() ([]byte, error) {
+	if msg.Graphics != nil {
+		return json.Marshal(graphics_msg{Graphics: msg.Graphics, TimeMs: msg.TimeMs})
+	}
+	type text_msg spectator_msg
+	return json.Marshal(text_msg(msg))
+}
+
+// streams the output of the run to ws until the run ended or ws was closed
+// if echo_stdin is set, the input of the run owner is sent as well
+func (rw *WebsocketRW) Spectate(ws *websocket.Conn, echo_stdin bool, max_spectators, send_buffer int, logger *slog.Logger) error {
+	err := rw.spectators.Serve(ws, echo_stdin, max_spectators, send_buffer, func(s *fanout.Conn[bool]) {
+		// late spectators get the output that is still kept for resuming
+		if rw.resume != nil {
+			for _, msg := range rw.resume.history.since(0) {
+				rw.spectators.Send(s, spectator_msg{Msg: msg.Msg, IsStderr: msg.IsStderr, TimeMs: msg.TimeMs, Graphics: msg.Graphics})
+			}
+		}
+	}, logger)
+	switch {
+	case errors.Is(err, fanout.ErrFull):
+		return ErrTooManySpectators
+	case errors.Is(err, fanout.ErrClosed):
+		return ErrGone
+	}
+	return err
+}
+
+// sends msg to every spectator, the input only to those that want it
+// writeMutex must be held
+func (rw *WebsocketRW) broadcastLocked(msg spectator_msg) {
+	rw.spectators.Broadcast(msg, func(echo_stdin bool) bool {
+		return !msg.IsStdin || echo_stdin
+	})
+}
@@ -1,77 +1,361 @@
 package websocket_rw
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
+	fanout "github.com/DDP-Projekt/Spielplatz/server/fan_out"
+	"github.com/DDP-Projekt/Spielplatz/server/graphics"
+	"github.com/DDP-Projekt/Spielplatz/server/transcript"
 	"github.com/gorilla/websocket"
 )
 
 const buff_size = 128
 
+// the first byte of the binary output frames
+const (
+	ChannelStdout byte = 1
+	ChannelStderr byte = 2
+)
+
+var (
+	// returned by Read when a client did not reattach within the grace period
+	ErrDisconnected = errors.New("client disconnected")
+	// returned by Attach when the grace period already ended
+	ErrGone = errors.New("the run is no longer attachable")
+)
+
 // implements io.ReadWriter on a websocket connection
 type WebsocketRW struct {
-	con        *websocket.Conn
-	cur_reader io.Reader
+	con        *websocket.Conn // nil while a resumable client is detached
+	binary     bool            // stdout and stderr are sent as binary frames, stdin may be sent as binary frames
+	v2         bool            // the client speaks ProtocolV2
 	isEOF      bool
 	readBuff   []byte
+	readOnce   sync.Once
+	inputs     chan input    // filled by readLoop
+	gone       chan struct{} // closed by readLoop when the client is gone
+	closed     chan struct{} // closed by Close
 	curWriter  io.WriteCloser
-	writeMutex *sync.Mutex
+	writeMutex *sync.Mutex          // guards con, curWriter, seq, start, resume and spectators
+	recorder   *transcript.Recorder // nil if the run is not recorded
+	seq        uint64               // sequence number of the last output message
+	start      time.Time            // start of the process, output times are relative to it
+	resume     *resumeState         // nil if clients cannot reattach
+	spectators *spectators          // closed after the run ended
+}
+
+type resumeState struct {
+	grace    time.Duration
+	history  *history
+	attached chan struct{} // closed and replaced whenever a connection is attached
+	gone     chan struct{} // closed when the grace period ended without reattach
+	timer    *time.Timer
 }
 
 func NewWebsocketRW(con *websocket.Conn) *WebsocketRW {
-	return &WebsocketRW{
+	rw := &WebsocketRW{
 		con:        con,
-		cur_reader: nil,
 		isEOF:      false,
 		readBuff:   make([]byte, 0, buff_size),
+		inputs:     make(chan input, 1),
+		gone:       make(chan struct{}),
+		closed:     make(chan struct{}),
 		curWriter:  nil,
 		writeMutex: &sync.Mutex{},
 	}
+	rw.spectators = fanout.New[bool](rw.writeMutex)
+	return rw
 }
 
-func (rw *WebsocketRW) getNextReader() (io.Reader, error) {
-	msg_type, r, err := rw.con.NextReader()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get next websocket reader: %w", err)
+// marks the start of the process, v2 clients are informed about it
+func (rw *WebsocketRW) Start() {
+	rw.writeMutex.Lock()
+	rw.start = time.Now()
+	if rw.v2 {
+		rw.writeMsgLocked(started_msg_v2{Type: "started"}, 0)
 	}
-	if msg_type != websocket.TextMessage {
-		return nil, errors.New("expected text message")
+	rw.writeMutex.Unlock()
+	if rw.recorder != nil {
+		rw.recorder.Start()
 	}
-	return r, nil
 }
 
-func (rw *WebsocketRW) Read(p []byte) (int, error) {
-	type Message struct {
-		Msg string `json:"msg"`
-		Eof bool   `json:"eof"`
+// records all input and output from now on in a transcript of at most max_size bytes
+func (rw *WebsocketRW) Record(max_size int) *transcript.Recorder {
+	rw.recorder = transcript.NewRecorder(max_size)
+	return rw.recorder
+}
+
+func (rw *WebsocketRW) record(stream transcript.Stream, data string) {
+	if rw.recorder != nil {
+		rw.recorder.Record(stream, data)
 	}
+}
 
-	if rw.isEOF {
-		return 0, io.EOF
+// sends stdout and stderr as binary frames that start with ChannelStdout or ChannelStderr
+// and accepts the raw bytes of binary frames as input, must be called before the run starts
+func (rw *WebsocketRW) EnableBinary() {
+	rw.binary = true
+}
+
+// allows clients to reattach within grace after the connection dropped
+// the last max_size bytes of output are kept to be sent again on reattach
+func (rw *WebsocketRW) EnableResume(max_size int, grace time.Duration) {
+	rw.writeMutex.Lock()
+	defer rw.writeMutex.Unlock()
+	rw.resume = &resumeState{
+		grace:    grace,
+		history:  newHistory(max_size),
+		attached: make(chan struct{}),
+		gone:     make(chan struct{}),
 	}
+}
 
-	if rw.cur_reader == nil {
-		var err error
-		if rw.cur_reader, err = rw.getNextReader(); err != nil {
-			rw.isEOF = true
-			return 0, err
+// replaces the connection of the client with con and
+// sends all kept output messages after last_seq to it
+func (rw *WebsocketRW) Attach(con *websocket.Conn, last_seq uint64) error {
+	rw.writeMutex.Lock()
+	defer rw.writeMutex.Unlock()
+	if rw.resume == nil {
+		return ErrGone
+	}
+	select {
+	case <-rw.resume.gone:
+		return ErrGone
+	default:
+	}
+
+	for _, msg := range rw.resume.history.since(last_seq) {
+		msg_type, data, err := rw.outputFrame(msg)
+		if err == nil {
+			err = con.WriteMessage(msg_type, data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resend output: %w", err)
 		}
 	}
 
+	if rw.resume.timer != nil {
+		rw.resume.timer.Stop()
+		rw.resume.timer = nil
+	}
+	// the old connection might still be open if the client noticed the drop first
+	if old := rw.con; old != nil {
+		if rw.curWriter != nil {
+			rw.curWriter.Close()
+			rw.curWriter = nil
+		}
+		old.Close()
+	}
+	rw.con = con
+	close(rw.resume.attached)
+	rw.resume.attached = make(chan struct{})
+	return nil
+}
+
+// marks con as dropped and starts the grace period
+// must be called with writeMutex held
+func (rw *WebsocketRW) detach(con *websocket.Conn) {
+	if rw.resume == nil || rw.con != con {
+		return
+	}
+	rw.con = nil
+	rw.curWriter = nil
+	gone := rw.resume.gone
+	rw.resume.timer = time.AfterFunc(rw.resume.grace, func() {
+		rw.writeMutex.Lock()
+		defer rw.writeMutex.Unlock()
+		if rw.con == nil {
+			closeOnce(gone)
+		}
+	})
+}
+
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// returns the current connection, waiting for a reattach if necessary
+// returns nil if the grace period ended
+func (rw *WebsocketRW) connection() *websocket.Conn {
+	for {
+		rw.writeMutex.Lock()
+		con := rw.con
+		if con != nil || rw.resume == nil {
+			rw.writeMutex.Unlock()
+			return con
+		}
+		attached, gone := rw.resume.attached, rw.resume.gone
+		rw.writeMutex.Unlock()
+
+		select {
+		case <-attached:
+		case <-gone:
+			return nil
+		}
+	}
+}
+
+// waits at most timeout for a detached client to reattach
+// used to deliver the end of a run to a client that is currently reconnecting
+func (rw *WebsocketRW) WaitAttached(timeout time.Duration) bool {
+	rw.writeMutex.Lock()
+	if rw.con != nil || rw.resume == nil {
+		attached := rw.con != nil
+		rw.writeMutex.Unlock()
+		return attached
+	}
+	attached, gone := rw.resume.attached, rw.resume.gone
+	rw.writeMutex.Unlock()
+
+	select {
+	case <-attached:
+		return true
+	case <-gone:
+	case <-time.After(timeout):
+	}
+	return false
+}
+
+func (rw *WebsocketRW) getNextReader() (int, io.Reader, error) {
+	for {
+		con := rw.connection()
+		if con == nil {
+			return 0, nil, ErrDisconnected
+		}
+		msg_type, r, err := con.NextReader()
+		if err != nil {
+			// a normal close means the client stopped on purpose
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				rw.writeMutex.Lock()
+				resumable := rw.resume != nil
+				if resumable {
+					rw.detach(con)
+				}
+				rw.writeMutex.Unlock()
+				if resumable {
+					continue
+				}
+			}
+			return 0, nil, fmt.Errorf("failed to get next websocket reader: %w", err)
+		}
+		if msg_type != websocket.TextMessage && (msg_type != websocket.BinaryMessage || !rw.binary) {
+			return 0, nil, errors.New("expected text message")
+		}
+		return msg_type, r, nil
+	}
+}
+
+// a message of the client
+type input struct {
+	Msg string `json:"msg"`
+	Eof bool   `json:"eof"`
+	err error  // the message was invalid or the client is gone
+}
+
+func decodeInput(msg_type int, r io.Reader) (msg input) {
+	if msg_type == websocket.BinaryMessage {
+		// binary frames are the raw input
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return input{err: fmt.Errorf("failed to read binary message: %w", err)}
+		}
+		return input{Msg: string(data)}
+	}
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		return input{err: fmt.Errorf("got invalid json message: %w", err)}
+	}
+	return msg
+}
+
+// reads the messages of the client in the background, so that a disconnect is noticed
+// even while the program does not read its input
+// messages after the end of the input are discarded
+func (rw *WebsocketRW) readLoop() {
+	eof := false
+	for {
+		msg_type, r, err := rw.getNextReader()
+		if err != nil {
+			closeOnce(rw.gone)
+			if !eof {
+				rw.sendInput(input{err: err})
+			}
+			return
+		}
+		msg := decodeInput(msg_type, r)
+		if eof {
+			continue
+		}
+		eof = msg.Eof
+		if !rw.sendInput(msg) {
+			return
+		}
+	}
+}
+
+func (rw *WebsocketRW) sendInput(msg input) bool {
+	select {
+	case rw.inputs <- msg:
+		return true
+	case <-rw.closed:
+		return false
+	}
+}
+
+func (rw *WebsocketRW) startReading() {
+	rw.readOnce.Do(func() {
+		go rw.readLoop()
+	})
+}
+
+// returns a context that is cancelled with ErrDisconnected once the client is gone
+// (resumable clients after the grace period), the messages of the client are read from now on
+func (rw *WebsocketRW) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	rw.startReading()
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-rw.gone:
+			cancel(ErrDisconnected)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+func (rw *WebsocketRW) Read(p []byte) (int, error) {
 	if len(rw.readBuff) != 0 {
 		n := copy(p, rw.readBuff)
 		rw.readBuff = rw.readBuff[n:]
 		return n, nil
 	}
 
-	var msg Message
-	err := json.NewDecoder(rw.cur_reader).Decode(&msg)
-	if err != nil {
-		return 0, fmt.Errorf("got invalid json message: %w", err)
+	if rw.isEOF {
+		return 0, io.EOF
+	}
+
+	rw.startReading()
+	var msg input
+	select {
+	case msg = <-rw.inputs:
+	case <-rw.closed:
+		rw.isEOF = true
+		return 0, io.EOF
+	}
+	if msg.err != nil {
+		// invalid messages end the input as well
+		rw.isEOF = true
+		return 0, msg.err
 	}
 
 	if msg.Eof {
@@ -79,8 +363,14 @@ func (rw *WebsocketRW) Read(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 
+	rw.record(transcript.Stdin, msg.Msg)
+	rw.writeMutex.Lock()
+	rw.broadcastLocked(spectator_msg{Msg: msg.Msg, IsStdin: true, TimeMs: rw.sinceStart()})
+	if rw.v2 {
+		rw.writeMsgLocked(stdin_ack_msg_v2{Type: "stdin-ack", Bytes: len(msg.Msg), TimeMs: rw.sinceStart()}, 0)
+	}
+	rw.writeMutex.Unlock()
 	rw.readBuff = []byte(msg.Msg)
-	rw.cur_reader = nil
 	n := copy(p, rw.readBuff)
 	rw.readBuff = rw.readBuff[n:]
 	return n, nil
@@ -89,22 +379,132 @@ func (rw *WebsocketRW) Read(p []byte) (int, error) {
 type ws_msg struct {
 	Msg      string `json:"msg"`
 	IsStderr bool   `json:"isStderr"`
+	Seq      uint64 `json:"seq"`
+	// milliseconds since the start of the process
+	TimeMs float64 `json:"timeMs"`
+	// set for drawing commands, which are sent as graphics_msg
+	Graphics *graphics.Command `json:"-"`
 }
 
-func (rw *WebsocketRW) writeMsg(msg ws_msg, n int) (int, error) {
+type graphics_msg struct {
+	Graphics *graphics.Command `json:"graphics"`
+	Seq      uint64            `json:"seq,omitempty"`
+	TimeMs   float64           `json:"timeMs"`
+}
+
+func (msg ws_msg) MarshalJSON() ([]byte, error) {
+	if msg.Graphics != nil {
+		return json.Marshal(graphics_msg{Graphics: msg.Graphics, Seq: msg.Seq, TimeMs: msg.TimeMs})
+	}
+	type text_msg ws_msg
+	return json.Marshal(text_msg(msg))
+}
+
+// roughly the number of bytes the message takes up
+func (msg ws_msg) size() int {
+	if msg.Graphics != nil {
+		return 64 + len(msg.Graphics.Text)
+	}
+	return len(msg.Msg)
+}
+
+// a file written by the program that can be downloaded from URL
+type OutputFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+func (rw *WebsocketRW) writeMsg(msg any, n int) (int, error) {
 	rw.writeMutex.Lock()
 	defer rw.writeMutex.Unlock()
+	return rw.writeMsgLocked(msg, n)
+}
+
+// must be called with writeMutex held
+func (rw *WebsocketRW) writeMsgLocked(msg any, n int) (int, error) {
+	// output of detached clients is only kept in the history
+	if rw.con == nil {
+		return n, nil
+	}
 	if rw.curWriter == nil {
 		w, err := rw.con.NextWriter(websocket.TextMessage)
 		if err != nil {
-			return 0, fmt.Errorf("error getting next websocket writer: %w", err)
+			return rw.writeFailed(n, fmt.Errorf("error getting next websocket writer: %w", err))
 		}
 		rw.curWriter = w
 	}
 	err := json.NewEncoder(rw.curWriter).Encode(msg)
-	rw.curWriter.Close()
+	if cerr := rw.curWriter.Close(); err == nil {
+		err = cerr
+	}
 	rw.curWriter = nil
-	return n, err
+	if err != nil {
+		return rw.writeFailed(n, err)
+	}
+	return n, nil
+}
+
+// resumable clients are detached instead of failing the write
+func (rw *WebsocketRW) writeFailed(n int, err error) (int, error) {
+	if rw.resume == nil {
+		return 0, err
+	}
+	rw.detach(rw.con)
+	return n, nil
+}
+
+// writes program output with the next sequence number
+func (rw *WebsocketRW) writeOutput(p []byte, is_stderr bool) (int, error) {
+	return rw.writeOutputMsg(ws_msg{Msg: string(p), IsStderr: is_stderr}, len(p))
+}
+
+func (rw *WebsocketRW) writeOutputMsg(msg ws_msg, n int) (int, error) {
+	rw.writeMutex.Lock()
+	defer rw.writeMutex.Unlock()
+	rw.seq++
+	msg.Seq, msg.TimeMs = rw.seq, rw.sinceStart()
+	if rw.resume != nil {
+		rw.resume.history.add(msg)
+	}
+	rw.broadcastLocked(spectator_msg{Msg: msg.Msg, IsStderr: msg.IsStderr, TimeMs: msg.TimeMs, Graphics: msg.Graphics})
+	if rw.binary && msg.Graphics == nil {
+		return rw.writeBinaryLocked(msg, n)
+	}
+	return rw.writeMsgLocked(rw.outputMsg(msg), n)
+}
+
+// must be called with writeMutex held
+func (rw *WebsocketRW) writeBinaryLocked(msg ws_msg, n int) (int, error) {
+	if rw.con == nil {
+		return n, nil
+	}
+	msg_type, data, _ := rw.outputFrame(msg)
+	if err := rw.con.WriteMessage(msg_type, data); err != nil {
+		return rw.writeFailed(n, fmt.Errorf("error writing binary message: %w", err))
+	}
+	return n, nil
+}
+
+// the websocket frame of an output message
+// in binary mode stdout and stderr are sent as binary frames with the channel as first byte
+// they carry no seq, every output message (including graphics) increments it by one
+func (rw *WebsocketRW) outputFrame(msg ws_msg) (int, []byte, error) {
+	if rw.binary && msg.Graphics == nil {
+		channel := ChannelStdout
+		if msg.IsStderr {
+			channel = ChannelStderr
+		}
+		return websocket.BinaryMessage, append([]byte{channel}, msg.Msg...), nil
+	}
+	data, err := json.Marshal(rw.outputMsg(msg))
+	return websocket.TextMessage, data, err
+}
+
+// sends a drawing command of the program, numbered like the output
+func (rw *WebsocketRW) WriteGraphics(cmd graphics.Command) error {
+	_, err := rw.writeOutputMsg(ws_msg{Graphics: &cmd}, 0)
+	return err
 }
 
 type stdoutWriter func([]byte) (int, error)
@@ -115,7 +515,8 @@ func (w stdoutWriter) Write(p []byte) (int, error) {
 
 func (rw *WebsocketRW) StdoutWriter() io.Writer {
 	return stdoutWriter(func(p []byte) (int, error) {
-		return rw.writeMsg(ws_msg{Msg: string(p), IsStderr: false}, len(p))
+		rw.record(transcript.Stdout, string(p))
+		return rw.writeOutput(p, false)
 	})
 }
 
@@ -127,13 +528,101 @@ func (w stderrWriter) Write(p []byte) (int, error) {
 
 func (rw *WebsocketRW) StderrWriter() io.Writer {
 	return stderrWriter(func(p []byte) (int, error) {
-		return rw.writeMsg(ws_msg{Msg: string(p), IsStderr: true}, len(p))
+		rw.record(transcript.Stderr, string(p))
+		return rw.writeOutput(p, true)
 	})
 }
 
+// informs a v2 client about its position in the process queue
+func (rw *WebsocketRW) WriteQueuePosition(pos int) error {
+	if !rw.v2 {
+		return nil
+	}
+	_, err := rw.writeMsg(queue_msg_v2{Type: "queue", Position: pos}, 0)
+	return err
+}
+
+// informs a v2 client about the id of the stored transcript of the run
+func (rw *WebsocketRW) WriteTranscriptID(id string) error {
+	if !rw.v2 {
+		return nil
+	}
+	_, err := rw.writeMsg(transcript_msg_v2{Type: "transcript", ID: id}, 0)
+	return err
+}
+
+// informs a v2 client about the files the program wrote
+func (rw *WebsocketRW) WriteOutputFiles(files []OutputFile) error {
+	if !rw.v2 {
+		return nil
+	}
+	_, err := rw.writeMsg(output_files_msg_v2{Type: "output-files", Files: files}, 0)
+	return err
+}
+
+// milliseconds since Start, 0 if the process was not started yet
+// must be called with writeMutex held
+func (rw *WebsocketRW) sinceStart() float64 {
+	if rw.start.IsZero() {
+		return 0
+	}
+	return float64(time.Since(rw.start)) / float64(time.Millisecond)
+}
+
+// informs a v2 client that the program waits for input or continues
+func (rw *WebsocketRW) WriteInputWait(waiting bool) error {
+	if !rw.v2 {
+		return nil
+	}
+	rw.writeMutex.Lock()
+	defer rw.writeMutex.Unlock()
+	_, err := rw.writeMsgLocked(input_wait_msg_v2{Type: "input-wait", Waiting: waiting, TimeMs: rw.sinceStart()}, 0)
+	return err
+}
+
+// informs a v2 client how the process ended and how long it ran
+func (rw *WebsocketRW) WriteEnd(end RunEnd) error {
+	if !rw.v2 {
+		return nil
+	}
+	_, err := rw.writeMsg(end.v2(), 0)
+	return err
+}
+
+// informs a v2 client how to reattach after the connection dropped
+func (rw *WebsocketRW) WriteResumeInfo(run_id, resume_token string) error {
+	if !rw.v2 {
+		return nil
+	}
+	_, err := rw.writeMsg(resume_msg_v2{Type: "resume", RunID: run_id, ResumeToken: resume_token}, 0)
+	return err
+}
+
+// sends a close frame to the currently attached connection and all spectators
+func (rw *WebsocketRW) WriteClose(code int, reason string) error {
+	rw.writeMutex.Lock()
+	defer rw.writeMutex.Unlock()
+	rw.spectators.Close(code, reason)
+	if rw.con == nil {
+		return ErrDisconnected
+	}
+	return rw.con.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
 func (rw *WebsocketRW) Close() error {
+	rw.writeMutex.Lock()
+	defer rw.writeMutex.Unlock()
+	closeOnce(rw.closed)
 	if rw.curWriter != nil {
 		rw.curWriter.Close()
+		rw.curWriter = nil
+	}
+	if rw.resume != nil {
+		if rw.resume.timer != nil {
+			rw.resume.timer.Stop()
+		}
+		// the run is over, nobody can reattach anymore
+		closeOnce(rw.resume.gone)
 	}
 	return nil
 }
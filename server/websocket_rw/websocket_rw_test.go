@@ -0,0 +1,156 @@
+package websocket_rw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DDP-Projekt/Spielplatz/server/graphics"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputFrame(t *testing.T) {
+	assert := assert.New(t)
+	rw := &WebsocketRW{}
+
+	msg_type, data, err := rw.outputFrame(ws_msg{Msg: "a\xff", Seq: 1})
+	assert.NoError(err)
+	assert.Equal(websocket.TextMessage, msg_type)
+	assert.Contains(string(data), `"seq":1`)
+
+	rw.EnableBinary()
+	msg_type, data, err = rw.outputFrame(ws_msg{Msg: "a\xff", Seq: 1})
+	assert.NoError(err)
+	assert.Equal(websocket.BinaryMessage, msg_type)
+	assert.Equal([]byte{ChannelStdout, 'a', 0xff}, data)
+
+	_, data, _ = rw.outputFrame(ws_msg{Msg: "\x00", IsStderr: true})
+	assert.Equal([]byte{ChannelStderr, 0}, data)
+
+	// drawing commands stay json
+	msg_type, _, _ = rw.outputFrame(ws_msg{Graphics: &graphics.Command{Type: "clear"}})
+	assert.Equal(websocket.TextMessage, msg_type)
+}
+
+func TestBinaryInput(t *testing.T) {
+	assert := assert.New(t)
+
+	result := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		rw := NewWebsocketRW(con)
+		rw.EnableBinary()
+		data, _ := io.ReadAll(rw)
+		result <- string(data)
+	}))
+	defer server.Close()
+
+	con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(err)
+	defer con.Close()
+	assert.NoError(con.WriteMessage(websocket.BinaryMessage, []byte{0, 0xff, '\n'}))
+	assert.NoError(con.WriteMessage(websocket.TextMessage, []byte(`{"msg": "text"}`)))
+	assert.NoError(con.WriteMessage(websocket.TextMessage, []byte(`{"eof": true}`)))
+	assert.Equal("\x00\xff\ntext", <-result)
+}
+
+func TestProtocolV2(t *testing.T) {
+	assert := assert.New(t)
+	rw := &WebsocketRW{}
+	rw.EnableV2()
+
+	assert.Equal(output_msg_v2{Type: "stderr", Data: "x", Seq: 2}, rw.outputMsg(ws_msg{Msg: "x", IsStderr: true, Seq: 2}))
+	assert.Equal("graphics", rw.outputMsg(ws_msg{Graphics: &graphics.Command{Type: "clear"}}).(output_msg_v2).Type)
+
+	assert.Equal("exited", RunEnd{ExitCode: 1}.v2().Reason)
+	assert.Equal("signal", RunEnd{ExitCode: -1, Signal: "SIGSEGV"}.v2().Reason)
+	exit := RunEnd{ExitCode: -1, Signal: "SIGKILL", Reason: "cpu_limit", CPUTime: 1500 * time.Millisecond, MaxRSS: 4096}.v2()
+	assert.Equal("cpu_limit", exit.Reason)
+	assert.Equal(rusage_v2{CPUTimeMs: 1500, MaxRSSBytes: 4096}, exit.Rusage)
+}
+
+// v1 clients only get the output, every text frame has msg
+func TestProtocolV1Frames(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v2 := range []bool{false, true} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			con, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer con.Close()
+			rw := NewWebsocketRW(con)
+			if v2 {
+				rw.EnableV2()
+			}
+			rw.WriteResumeInfo("run", "token")
+			rw.WriteQueuePosition(1)
+			rw.Start()
+			rw.WriteInputWait(true)
+			rw.StdoutWriter().Write([]byte("x"))
+			rw.WriteOutputFiles([]OutputFile{{Name: "a.txt"}})
+			rw.WriteTranscriptID("transcript")
+			rw.WriteEnd(RunEnd{ExitCode: 0})
+			rw.WriteClose(websocket.CloseNormalClosure, "")
+		}))
+
+		con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		assert.NoError(err)
+		var frames []map[string]any
+		for {
+			var frame map[string]any
+			if err := con.ReadJSON(&frame); err != nil {
+				break
+			}
+			frames = append(frames, frame)
+		}
+		con.Close()
+		server.Close()
+
+		if v2 {
+			assert.Len(frames, 8)
+			continue
+		}
+		if assert.Len(frames, 1) {
+			assert.Equal("x", frames[0]["msg"])
+		}
+	}
+}
+
+func TestContextCancelledOnDisconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	cause := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		// the program does not read any input
+		ctx, cancel := NewWebsocketRW(con).Context(context.Background())
+		defer cancel()
+		select {
+		case <-ctx.Done():
+			cause <- context.Cause(ctx)
+		case <-time.After(5 * time.Second):
+			cause <- nil
+		}
+	}))
+	defer server.Close()
+
+	con, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(err)
+	con.Close()
+	assert.ErrorIs(<-cause, ErrDisconnected)
+}